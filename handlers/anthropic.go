@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"deepinfra-wrapper/types"
+)
+
+// MessagesHandler implements the Anthropic Messages API on top of the chat
+// completions pipeline
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Printf("💬 Anthropic messages request from %s\n", r.RemoteAddr)
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		sendAnthropicError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	r.Body.Close()
+
+	var req types.AnthropicMessagesRequest
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		sendAnthropicError(w, http.StatusBadRequest, "Failed to parse request body: "+err.Error())
+		return
+	}
+	if req.MaxTokens <= 0 {
+		sendAnthropicError(w, http.StatusBadRequest, "max_tokens: Field required")
+		return
+	}
+
+	chatReq, err := anthropicToChatRequest(req)
+	if err != nil {
+		sendAnthropicError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Stream {
+		streamAnthropicMessages(w, r, chatReq, req.StopSequences)
+		return
+	}
+
+	recorder := newChunkStreamWriter()
+	runChatPipeline(recorder, r, chatReq)
+	copyPipelineHeaders(w, recorder.Header())
+	if recorder.Status() != http.StatusOK {
+		sendAnthropicError(w, recorder.Status(), pipelineErrorMessage(recorder.Body.Bytes()))
+		return
+	}
+
+	var completion types.ChatCompletionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &completion); err != nil {
+		sendAnthropicError(w, http.StatusBadGateway, "Failed to parse upstream response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(chatToAnthropicResponse(completion, req.StopSequences))
+}
+
+// anthropicToChatRequest translates a Messages request into a chat
+// completion request. Tool results become tool messages and tool uses
+// become assistant tool calls.
+func anthropicToChatRequest(req types.AnthropicMessagesRequest) (types.ChatCompletionRequest, error) {
+	maxTokens := req.MaxTokens
+	chatReq := types.ChatCompletionRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   &maxTokens,
+	}
+	if req.Stream {
+		chatReq.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+	}
+	if req.Metadata != nil {
+		chatReq.User = req.Metadata.UserID
+	}
+	if len(req.StopSequences) > 0 {
+		chatReq.Stop, _ = json.Marshal(req.StopSequences)
+	}
+
+	if system := anthropicText(req.System); system != "" {
+		chatReq.Messages = append(chatReq.Messages, types.ChatMessage{Role: "system", Content: types.TextContent(system)})
+	}
+
+	for _, message := range req.Messages {
+		switch message.Role {
+		case "user":
+			chatReq.Messages = append(chatReq.Messages, anthropicUserMessages(message.Content)...)
+		case "assistant":
+			chatReq.Messages = append(chatReq.Messages, anthropicAssistantMessage(message.Content))
+		default:
+			return chatReq, fmt.Errorf("messages: unexpected role %q", message.Role)
+		}
+	}
+
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, len(req.Tools))
+		for i, tool := range req.Tools {
+			function := map[string]interface{}{"name": tool.Name, "parameters": tool.InputSchema}
+			if tool.Description != "" {
+				function["description"] = tool.Description
+			}
+			tools[i] = map[string]interface{}{"type": "function", "function": function}
+		}
+		chatReq.Tools, _ = json.Marshal(tools)
+	}
+
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "auto", "none":
+			chatReq.ToolChoice, _ = json.Marshal(req.ToolChoice.Type)
+		case "any":
+			chatReq.ToolChoice, _ = json.Marshal("required")
+		case "tool":
+			chatReq.ToolChoice, _ = json.Marshal(map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": req.ToolChoice.Name},
+			})
+		default:
+			return chatReq, fmt.Errorf("tool_choice: unexpected type %q", req.ToolChoice.Type)
+		}
+	}
+	return chatReq, nil
+}
+
+// anthropicUserMessages converts a user turn. Tool results are sent first as
+// separate tool messages, followed by the remaining text and images.
+func anthropicUserMessages(content types.AnthropicContent) []types.ChatMessage {
+	var messages []types.ChatMessage
+	var parts []types.ContentPart
+	hasImages := false
+
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, types.ContentPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+			}
+			parts = append(parts, types.ContentPart{Type: "image_url", ImageURL: &types.ImageURL{URL: url}})
+			hasImages = true
+		case "tool_result":
+			text := anthropicText(block.Content)
+			if block.IsError {
+				text = "Error: " + text
+			}
+			messages = append(messages, types.ChatMessage{
+				Role:       "tool",
+				Content:    types.TextContent(text),
+				ToolCallID: block.ToolUseID,
+			})
+		}
+	}
+
+	switch {
+	case hasImages:
+		messages = append(messages, types.ChatMessage{Role: "user", Content: types.MessageContent{Parts: parts}})
+	case len(parts) > 0:
+		texts := make([]string, len(parts))
+		for i, part := range parts {
+			texts[i] = part.Text
+		}
+		messages = append(messages, types.ChatMessage{Role: "user", Content: types.TextContent(strings.Join(texts, "\n"))})
+	}
+	return messages
+}
+
+func anthropicAssistantMessage(content types.AnthropicContent) types.ChatMessage {
+	message := types.ChatMessage{Role: "assistant"}
+	var texts []string
+	var calls []types.ToolCall
+
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			calls = append(calls, types.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: block.Name, Arguments: arguments},
+			})
+		}
+	}
+
+	if len(texts) == 0 && len(calls) > 0 {
+		message.Content = types.NullContent()
+	} else {
+		message.Content = types.TextContent(strings.Join(texts, "\n"))
+	}
+	if len(calls) > 0 {
+		message.ToolCalls, _ = json.Marshal(calls)
+	}
+	return message
+}
+
+// anthropicText joins the text blocks of content
+func anthropicText(content types.AnthropicContent) string {
+	var texts []string
+	for _, block := range content {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatToAnthropicResponse converts the first choice of a completion into a
+// Messages response
+func chatToAnthropicResponse(completion types.ChatCompletionResponse, stopSequences []string) types.AnthropicMessageResponse {
+	response := types.AnthropicMessageResponse{
+		ID:      anthropicMessageID(completion.ID),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   completion.Model,
+		Content: []types.AnthropicBlock{},
+	}
+	if completion.Usage != nil {
+		response.Usage = types.AnthropicUsage{
+			InputTokens:  completion.Usage.PromptTokens,
+			OutputTokens: completion.Usage.CompletionTokens,
+		}
+	}
+	if len(completion.Choices) == 0 {
+		return response
+	}
+
+	choice := completion.Choices[0]
+	if text := choice.Message.Content.String(); text != "" {
+		response.Content = append(response.Content, types.AnthropicBlock{Type: "text", Text: text})
+	}
+
+	var calls []types.ToolCall
+	if len(choice.Message.ToolCalls) > 0 {
+		json.Unmarshal(choice.Message.ToolCalls, &calls)
+	}
+	for _, call := range calls {
+		input := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		response.Content = append(response.Content, types.AnthropicBlock{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: input,
+		})
+	}
+
+	finishReason := ""
+	if choice.FinishReason != nil {
+		finishReason = *choice.FinishReason
+	}
+	stopReason, stopSequence := anthropicStopReason(finishReason, choice.StopReason, stopSequences)
+	response.StopReason = &stopReason
+	response.StopSequence = stopSequence
+	return response
+}
+
+// anthropicStopReason maps an OpenAI finish reason to a Messages stop
+// reason. A stop finish is reported as stop_sequence, together with the
+// sequence, when the upstream stop reason names one of the request's
+// stop sequences.
+func anthropicStopReason(finishReason string, upstreamStopReason json.RawMessage, stopSequences []string) (string, *string) {
+	switch finishReason {
+	case "length":
+		return "max_tokens", nil
+	case "tool_calls", "function_call":
+		return "tool_use", nil
+	case "stop":
+		var matched string
+		if json.Unmarshal(upstreamStopReason, &matched) == nil {
+			for _, sequence := range stopSequences {
+				if sequence == matched {
+					return "stop_sequence", &sequence
+				}
+			}
+		}
+	}
+	return "end_turn", nil
+}
+
+func anthropicMessageID(id string) string {
+	if strings.HasPrefix(id, "msg_") {
+		return id
+	}
+	return "msg_" + strings.TrimPrefix(id, "chatcmpl-")
+}
+
+// streamAnthropicMessages runs a streamed request and re-emits the chunks
+// as Messages API events
+func streamAnthropicMessages(w http.ResponseWriter, r *http.Request, chatReq types.ChatCompletionRequest, stopSequences []string) {
+	clearWriteDeadline(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	blockIndex := -1
+	openBlock := ""
+	toolIndex := -1
+	stopReason := "end_turn"
+	var stopSequence *string
+	finished := false
+	var usage types.AnthropicUsage
+
+	closeBlock := func() {
+		if openBlock != "" {
+			send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": blockIndex})
+			openBlock = ""
+		}
+	}
+	openNewBlock := func(kind string, block map[string]interface{}) {
+		closeBlock()
+		blockIndex++
+		openBlock = kind
+		send("content_block_start", map[string]interface{}{"type": "content_block_start", "index": blockIndex, "content_block": block})
+	}
+
+	stream := newChunkStreamWriter()
+	started := false
+	stream.OnStart = func(header http.Header) {
+		copyPipelineHeaders(w, header)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+	}
+	stream.OnChunk = func(chunk types.ChatCompletionChunk) {
+		if !started {
+			started = true
+			send("message_start", map[string]interface{}{
+				"type": "message_start",
+				"message": map[string]interface{}{
+					"id":            anthropicMessageID(chunk.ID),
+					"type":          "message",
+					"role":          "assistant",
+					"model":         chunk.Model,
+					"content":       []interface{}{},
+					"stop_reason":   nil,
+					"stop_sequence": nil,
+					"usage":         types.AnthropicUsage{},
+				},
+			})
+			send("ping", map[string]string{"type": "ping"})
+		}
+		if chunk.Usage != nil {
+			usage = types.AnthropicUsage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if content := choice.Delta.Content; content != nil && *content != "" {
+				if openBlock != "text" {
+					openNewBlock("text", map[string]interface{}{"type": "text", "text": ""})
+				}
+				send("content_block_delta", map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": blockIndex,
+					"delta": map[string]string{"type": "text_delta", "text": *content},
+				})
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				if openBlock != "tool_use" || call.Index != toolIndex {
+					toolIndex = call.Index
+					openNewBlock("tool_use", map[string]interface{}{
+						"type":  "tool_use",
+						"id":    call.ID,
+						"name":  call.Function.Name,
+						"input": map[string]interface{}{},
+					})
+				}
+				if call.Function.Arguments != "" {
+					send("content_block_delta", map[string]interface{}{
+						"type":  "content_block_delta",
+						"index": blockIndex,
+						"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+					})
+				}
+			}
+			if choice.FinishReason != nil {
+				stopReason, stopSequence = anthropicStopReason(*choice.FinishReason, choice.StopReason, stopSequences)
+				finished = true
+			}
+		}
+	}
+	stream.OnDone = func() {
+		if !started {
+			return
+		}
+		closeBlock()
+		send("message_delta", map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": stopSequence},
+			"usage": map[string]int{"output_tokens": usage.OutputTokens},
+		})
+		send("message_stop", map[string]string{"type": "message_stop"})
+	}
+
+	runChatPipeline(stream, r, chatReq)
+
+	if !stream.Streaming() {
+		copyPipelineHeaders(w, stream.Header())
+		sendAnthropicError(w, stream.Status(), pipelineErrorMessage(stream.Body.Bytes()))
+		return
+	}
+	if !stream.Done() {
+		switch {
+		case stream.StreamError != "":
+			send("error", anthropicErrorBody(http.StatusBadGateway, stream.StreamError))
+		case finished:
+			stream.finish()
+		default:
+			send("error", anthropicErrorBody(http.StatusBadGateway, "The upstream stream ended unexpectedly"))
+		}
+	}
+}
+
+func anthropicErrorBody(status int, message string) types.AnthropicError {
+	var body types.AnthropicError
+	body.Type = "error"
+	body.Error.Message = message
+	switch status {
+	case http.StatusBadRequest:
+		body.Error.Type = "invalid_request_error"
+	case http.StatusUnauthorized:
+		body.Error.Type = "authentication_error"
+	case http.StatusForbidden:
+		body.Error.Type = "permission_error"
+	case http.StatusNotFound:
+		body.Error.Type = "not_found_error"
+	case http.StatusTooManyRequests:
+		body.Error.Type = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		body.Error.Type = "overloaded_error"
+	default:
+		body.Error.Type = "api_error"
+	}
+	return body
+}
+
+func sendAnthropicError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropicErrorBody(status, message))
+}
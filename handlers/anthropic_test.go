@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAnthropicStopReason(t *testing.T) {
+	stopSequences := []string{"###", "END"}
+	tests := []struct {
+		name         string
+		finishReason string
+		stopReason   string
+		want         string
+		wantSequence string
+	}{
+		{name: "natural end", finishReason: "stop", want: "end_turn"},
+		{name: "stop sequence hit", finishReason: "stop", stopReason: `"END"`, want: "stop_sequence", wantSequence: "END"},
+		{name: "unknown stop string", finishReason: "stop", stopReason: `"</s>"`, want: "end_turn"},
+		{name: "stop token id", finishReason: "stop", stopReason: `128009`, want: "end_turn"},
+		{name: "length", finishReason: "length", stopReason: `"END"`, want: "max_tokens"},
+		{name: "tool calls", finishReason: "tool_calls", want: "tool_use"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var upstream json.RawMessage
+			if test.stopReason != "" {
+				upstream = json.RawMessage(test.stopReason)
+			}
+			got, sequence := anthropicStopReason(test.finishReason, upstream, stopSequences)
+			if got != test.want {
+				t.Errorf("stop reason = %q, want %q", got, test.want)
+			}
+			gotSequence := ""
+			if sequence != nil {
+				gotSequence = *sequence
+			}
+			if gotSequence != test.wantSequence {
+				t.Errorf("stop sequence = %q, want %q", gotSequence, test.wantSequence)
+			}
+		})
+	}
+}
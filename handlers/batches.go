@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/utils"
+)
+
+// maxBatchFileBytes is the largest batch input file accepted for upload
+const maxBatchFileBytes = 200 << 20
+
+// batchUploadTimeout replaces the server's read and write timeouts for
+// uploads, which are too short for a file of maxBatchFileBytes
+const batchUploadTimeout = 30 * time.Minute
+
+// FilesHandler lists files and uploads batch input files
+func FilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := services.ListFiles(requestKeyName(r), r.URL.Query().Get("purpose"))
+		if list == nil {
+			list = []services.FileObject{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": list})
+	case http.MethodPost:
+		uploadFile(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func uploadFile(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Now().Add(batchUploadTimeout))
+	controller.SetWriteDeadline(time.Now().Add(batchUploadTimeout))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchFileBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.SendErrorResponse(w, "Files must be uploaded as multipart/form-data", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	// The purpose field has to come before the file so the upload can be
+	// streamed to disk without buffering it
+	purpose := ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.SendErrorResponse(w, "Failed to read upload: "+err.Error(), "invalid_request_error", http.StatusBadRequest)
+			return
+		}
+
+		switch part.FormName() {
+		case "purpose":
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			purpose = strings.TrimSpace(string(value))
+		case "file":
+			if purpose != services.FilePurposeBatch {
+				utils.SendErrorResponse(w, fmt.Sprintf("purpose must be %q and sent before the file", services.FilePurposeBatch), "invalid_request_error", http.StatusBadRequest)
+				return
+			}
+			file, err := services.CreateFile(requestKeyName(r), part.FileName(), purpose, part)
+			if err != nil {
+				fmt.Printf("❌ File upload failed: %v\n", err)
+				utils.SendErrorResponse(w, "Failed to store file: "+err.Error(), "invalid_request_error", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(file)
+			return
+		}
+	}
+
+	utils.SendErrorResponse(w, "Missing file", "invalid_request_error", http.StatusBadRequest)
+}
+
+// FileHandler serves /v1/files/{id} and /v1/files/{id}/content
+func FileHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	id, wantContent := strings.CutSuffix(path, "/content")
+	keyName := requestKeyName(r)
+
+	file, contentPath, err := services.GetFile(keyName, id)
+	if err != nil {
+		utils.SendErrorResponse(w, fmt.Sprintf("No such File object: %s", id), "invalid_request_error", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && wantContent:
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+		http.ServeFile(w, r, contentPath)
+	case r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(file)
+	case r.Method == http.MethodDelete && !wantContent:
+		services.DeleteFile(keyName, id)
+		fmt.Printf("🗑️ Deleted file %s\n", id)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "object": "file", "deleted": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// BatchesHandler creates and lists batches
+func BatchesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listBatches(w, r)
+	case http.MethodPost:
+		createBatch(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, "Failed to parse request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	batch, err := services.CreateBatch(requestKeyName(r), req.InputFileID, req.Endpoint, req.CompletionWindow, req.Metadata)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(batch)
+}
+
+func listBatches(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			utils.SendErrorResponse(w, "limit must be an integer between 1 and 100", "invalid_request_error", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	list := services.ListBatches(requestKeyName(r))
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, batch := range list {
+			if batch.ID == after {
+				list = list[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+
+	response := map[string]interface{}{
+		"object":   "list",
+		"data":     list,
+		"has_more": hasMore,
+	}
+	if len(list) > 0 {
+		response["first_id"] = list[0].ID
+		response["last_id"] = list[len(list)-1].ID
+	} else {
+		response["data"] = []services.Batch{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// BatchHandler serves /v1/batches/{id} and /v1/batches/{id}/cancel
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/batches/")
+	id, cancel := strings.CutSuffix(path, "/cancel")
+
+	var batch services.Batch
+	var err error
+	switch {
+	case r.Method == http.MethodPost && cancel:
+		batch, err = services.CancelBatch(requestKeyName(r), id)
+	case r.Method == http.MethodGet && !cancel:
+		batch, err = services.GetBatch(requestKeyName(r), id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, services.ErrNotFound) {
+		utils.SendErrorResponse(w, fmt.Sprintf("No such Batch object: %s", id), "invalid_request_error", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(batch)
+}
+
+// ExecuteBatchRequest runs one batch request through the chat completions
+// handler as if the owning API key had sent it
+func ExecuteBatchRequest(ctx context.Context, keyName string, body []byte) (int, []byte) {
+	ctx = context.WithValue(ctx, keyNameContextKey, keyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, services.BatchEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, []byte(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "batch"
+
+	recorder := newResponseRecorder()
+	ChatCompletionsHandler(recorder, req)
+	if recorder.status == 0 {
+		return 0, []byte("The request got no response")
+	}
+	return recorder.status, recorder.body.Bytes()
+}
+
+// responseRecorder captures a response produced in-process
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header)}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) Flush() {}
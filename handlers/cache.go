@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+// isCacheableRequest reports whether a chat request may be answered from
+// the response cache: its output is deterministic (temperature 0) or the
+// client opted in with X-Use-Cache. Cache-Control: no-cache always bypasses
+// the cache.
+func isCacheableRequest(chatReq types.ChatCompletionRequest, r *http.Request) bool {
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") {
+		return false
+	}
+	if strings.EqualFold(r.Header.Get("X-Use-Cache"), "true") {
+		return true
+	}
+	return chatReq.Temperature != nil && *chatReq.Temperature == 0
+}
+
+// serveCachedResponse writes a cached completion, replayed as server-sent
+// events when the client asked for a stream
+func serveCachedResponse(w http.ResponseWriter, cached services.CachedResponse, chatReq types.ChatCompletionRequest, responseModel string) {
+	if responseModel == "" {
+		responseModel = cached.Model
+	}
+	body := []byte(cached.Body)
+	var served struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &served) == nil && served.Model != responseModel {
+		body = rewriteResponseModel(body, responseModel)
+	}
+
+	w.Header().Set("X-Served-Model", cached.Model)
+
+	if !chatReq.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+		return
+	}
+
+	var completion types.ChatCompletionResponse
+	json.Unmarshal(body, &completion)
+	writeCompletionStream(w, completion, chatReq.WantsStreamUsage())
+}
+
+// writeCompletionStream sends a complete completion as server-sent events,
+// returning the number of chunks written. The usage chunk is only sent with
+// includeUsage.
+func writeCompletionStream(w http.ResponseWriter, completion types.ChatCompletionResponse, includeUsage bool) int {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	chunks := services.CompletionToChunks(completion, includeUsage)
+	flusher, _ := w.(http.Flusher)
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return len(chunks)
+}
+
+// capturedCompletion turns the response captured while it was sent to the
+// client into a completion body for the caches. Streams only yield one when
+// they ran to completion.
+func capturedCompletion(captured []byte, isStream bool) ([]byte, bool) {
+	if !isStream {
+		return captured, json.Valid(captured)
+	}
+
+	var aggregator services.CompletionAggregator
+	scanner := bufio.NewScanner(bytes.NewReader(captured))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		payload := strings.TrimPrefix(scanner.Text(), "data: ")
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		var chunk types.ChatCompletionChunk
+		if json.Unmarshal([]byte(payload), &chunk) == nil {
+			aggregator.Add(chunk)
+		}
+	}
+	if !aggregator.Finished() {
+		return nil, false
+	}
+	body, err := json.Marshal(aggregator.Completion())
+	return body, err == nil
+}
+
+// wantsSemanticCache reports whether the client opted in to semantic
+// caching for a request. Requests with images are never matched on text.
+func wantsSemanticCache(chatReq types.ChatCompletionRequest, r *http.Request) bool {
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") {
+		return false
+	}
+	return strings.EqualFold(r.Header.Get("X-Semantic-Cache"), "true") && !chatReq.HasImages()
+}
+
+// lastUserMessage returns the text of the most recent user message
+func lastUserMessage(chatReq types.ChatCompletionRequest) string {
+	for i := len(chatReq.Messages) - 1; i >= 0; i-- {
+		if chatReq.Messages[i].Role == "user" {
+			return chatReq.Messages[i].Content.String()
+		}
+	}
+	return ""
+}
+
+// semanticContext hashes everything in the request except its last user
+// message: system prompt, earlier turns, tools, tool choice, response format
+// and sampling parameters. Only requests that agree on all of it may share
+// a semantically cached answer, so a short follow-up such as "continue" never
+// matches the answer to another conversation.
+func semanticContext(chatReq types.ChatCompletionRequest) string {
+	for i := len(chatReq.Messages) - 1; i >= 0; i-- {
+		if chatReq.Messages[i].Role == "user" {
+			messages := append([]types.ChatMessage{}, chatReq.Messages[:i]...)
+			chatReq.Messages = append(messages, chatReq.Messages[i+1:]...)
+			break
+		}
+	}
+	// The API key is already part of the semantic scope
+	return services.ResponseCacheKey("", chatReq)
+}
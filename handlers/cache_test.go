@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+func TestIsCacheableRequest(t *testing.T) {
+	zero, warm := 0.0, 0.7
+	seed := int64(7)
+	tools := json.RawMessage(`[{"type":"function","function":{"name":"lookup"}}]`)
+
+	tests := []struct {
+		name    string
+		chatReq types.ChatCompletionRequest
+		headers map[string]string
+		want    bool
+	}{
+		{name: "temperature 0", chatReq: types.ChatCompletionRequest{Temperature: &zero}, want: true},
+		{name: "default temperature", chatReq: types.ChatCompletionRequest{}, want: false},
+		{name: "sampled", chatReq: types.ChatCompletionRequest{Temperature: &warm}, want: false},
+		// All n choices are cached together under a key that includes n
+		{name: "several choices at temperature 0", chatReq: types.ChatCompletionRequest{Temperature: &zero, N: 3}, want: true},
+		// A seed alone does not make sampling deterministic upstream
+		{name: "seeded sampling", chatReq: types.ChatCompletionRequest{Temperature: &warm, Seed: &seed}, want: false},
+		{name: "seeded sampling opted in", chatReq: types.ChatCompletionRequest{Temperature: &warm, Seed: &seed}, headers: map[string]string{"X-Use-Cache": "true"}, want: true},
+		{name: "tools at temperature 0", chatReq: types.ChatCompletionRequest{Temperature: &zero, Tools: tools}, want: true},
+		{name: "no-cache", chatReq: types.ChatCompletionRequest{Temperature: &zero}, headers: map[string]string{"Cache-Control": "no-cache"}, want: false},
+		{name: "no-store beats opt-in", chatReq: types.ChatCompletionRequest{Temperature: &warm}, headers: map[string]string{"Cache-Control": "no-store", "X-Use-Cache": "true"}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := isCacheableRequest(test.chatReq, r); got != test.want {
+				t.Errorf("isCacheableRequest() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResponseTrackerCaptureLimit(t *testing.T) {
+	if err := services.InitResponseCache("", "", 0, 0, 16); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { services.InitResponseCache("", "", 0, 0, 0) })
+
+	recorder := httptest.NewRecorder()
+	tracker := &responseTracker{ResponseWriter: recorder, capture: &bytes.Buffer{}}
+
+	tracker.Write([]byte("0123456789"))
+	if tracker.capture == nil || tracker.capture.String() != "0123456789" {
+		t.Fatalf("capture = %v, want the first write", tracker.capture)
+	}
+
+	tracker.Write([]byte("0123456789"))
+	if tracker.capture != nil {
+		t.Errorf("capture kept growing past the cache size bound: %d bytes", tracker.capture.Len())
+	}
+	if recorder.Body.Len() != 20 {
+		t.Errorf("client received %d bytes, want 20", recorder.Body.Len())
+	}
+}
+
+func TestSemanticContext(t *testing.T) {
+	message := func(role, content string) types.ChatMessage {
+		return types.ChatMessage{Role: role, Content: types.TextContent(content)}
+	}
+	conversation := func(last string) types.ChatCompletionRequest {
+		return types.ChatCompletionRequest{
+			Model: "model-x",
+			Messages: []types.ChatMessage{
+				message("system", "You are a travel agent."),
+				message("user", "Plan a trip to Rome."),
+				message("assistant", "Here is a plan for Rome."),
+				message("user", last),
+			},
+		}
+	}
+	base := semanticContext(conversation("continue"))
+
+	if semanticContext(conversation("go on please")) != base {
+		t.Error("requests differing only in the last user message have different contexts")
+	}
+
+	changes := map[string]func(*types.ChatCompletionRequest){
+		"system prompt": func(r *types.ChatCompletionRequest) { r.Messages[0] = message("system", "You are a chef.") },
+		"earlier turn":  func(r *types.ChatCompletionRequest) { r.Messages[1] = message("user", "Plan a trip to Oslo.") },
+		"tools": func(r *types.ChatCompletionRequest) {
+			r.Tools = json.RawMessage(`[{"type":"function","function":{"name":"book"}}]`)
+		},
+		"tool_choice":     func(r *types.ChatCompletionRequest) { r.ToolChoice = json.RawMessage(`"required"`) },
+		"response_format": func(r *types.ChatCompletionRequest) { r.ResponseFormat = json.RawMessage(`{"type":"json_object"}`) },
+		"temperature":     func(r *types.ChatCompletionRequest) { warm := 0.7; r.Temperature = &warm },
+	}
+	for name, change := range changes {
+		chatReq := conversation("continue")
+		change(&chatReq)
+		if semanticContext(chatReq) == base {
+			t.Errorf("changing the %s does not change the semantic context", name)
+		}
+	}
+
+	if lastUserMessage(conversation("continue")) != "continue" {
+		t.Error("lastUserMessage does not return the final user message")
+	}
+}
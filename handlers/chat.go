@@ -1,16 +1,17 @@
 package handlers
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"deepinfra-wrapper/services"
@@ -54,128 +55,637 @@ func ChatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("🤖 Model requested: %s\n", chatReq.Model)
 
-	if !services.IsModelSupported(chatReq.Model) {
-		fmt.Printf("❌ Unsupported model: %s\n", chatReq.Model)
-		utils.SendErrorResponse(w, "Unsupported model. Please use one of the supported models.", "invalid_request_error", http.StatusBadRequest, "model_not_found")
+	plan := planRoute(chatReq, r)
+	if plan.Route != "" {
+		fmt.Printf("🧭 Route %s matched, sending %s to %s\n", plan.Route, plan.RequestedModel, plan.RoutedModel)
+		w.Header().Set("X-Route", plan.Route)
+	}
+	chain := plan.Models
+	chatReq.Model = chain[0]
+	if chain[0] != plan.RoutedModel {
+		fmt.Printf("🔀 Alias %s resolved to %s\n", plan.RoutedModel, chain[0])
+	}
+
+	if !services.SupportsChat(chatReq.Model) {
+		modelType := services.GetModelType(chatReq.Model)
+		fmt.Printf("❌ Model %s is a %s model, rejecting chat request\n", chatReq.Model, modelType)
+		utils.SendErrorResponse(w, fmt.Sprintf("The model '%s' is not a chat model (type: %s) and does not support chat completions.", plan.RequestedModel, modelType), "invalid_request_error", http.StatusBadRequest, "model_not_supported")
 		return
 	}
 
-	if chatReq.Temperature == 0 {
-		chatReq.Temperature = 0.7
+	var models []string
+	for _, model := range chain {
+		if services.IsModelSupported(model) && services.SupportsChat(model) {
+			models = append(models, model)
+		} else if model != chatReq.Model {
+			fmt.Printf("⚠️ Skipping unsupported fallback model: %s\n", model)
+		}
 	}
-	if chatReq.MaxTokens == 0 {
-		chatReq.MaxTokens = 15000
+
+	if len(models) == 0 {
+		fmt.Printf("❌ Unsupported model: %s\n", chatReq.Model)
+		utils.SendErrorResponse(w, "Unsupported model. Please use one of the supported models.", "invalid_request_error", http.StatusBadRequest, "model_not_found")
+		return
+	}
+	if models[0] != chatReq.Model {
+		fmt.Printf("⚠️ Model %s is unsupported, falling back to %s\n", chatReq.Model, models[0])
 	}
 
 	for i := range chatReq.Messages {
-		if chatReq.Messages[i].Role == "content" && chatReq.Messages[i].Content == "user" {
-			chatReq.Messages[i].Role, chatReq.Messages[i].Content = chatReq.Messages[i].Content, chatReq.Messages[i].Role
+		if chatReq.Messages[i].Role == "content" && chatReq.Messages[i].Content.String() == "user" {
+			chatReq.Messages[i].Role, chatReq.Messages[i].Content = "user", types.TextContent("content")
 		}
 	}
 
-	data, err := json.Marshal(chatReq)
+	// Policies and managed prompts are resolved again for each fallback, so
+	// the request always carries the ones of the model serving it
+	present := presentFields(bodyBytes)
+	baseReq := chatReq
+	chatReq, err = prepareChatRequest(baseReq, models[0], plan, r, present)
 	if err != nil {
-		fmt.Printf("❌ Failed to marshal request: %v\n", err)
-		utils.SendErrorResponse(w, "Failed to marshal request", "internal_error", http.StatusInternalServerError)
+		fmt.Printf("❌ Parameter policy violation: %v\n", err)
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusBadRequest, "parameter_not_allowed")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 90*time.Second)
+	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	success := false
+	primaryModel := chain[0]
+	tracker := &responseTracker{ResponseWriter: w}
+
+	// The deadline only covers the wait for the response to begin; a stream
+	// that is under way may run as long as it keeps producing output, which
+	// the stall timeout watches
+	firstByteDeadline := time.AfterFunc(90*time.Second, func() {
+		if !tracker.Started() {
+			cancel()
+		}
+	})
+	defer firstByteDeadline.Stop()
+
+	cacheKey := ""
+	if services.IsResponseCacheEnabled() && isCacheableRequest(chatReq, r) {
+		cacheKey = services.ResponseCacheKey(requestKeyName(r), chatReq)
+		if cached, hit := services.GetCachedResponse(cacheKey); hit {
+			fmt.Printf("🗄️ Serving cached response for %s\n", primaryModel)
+			w.Header().Set("X-Cache", "HIT")
+			serveCachedResponse(w, cached, chatReq, plan.responseModel())
+			services.RecordRequestOutcome(services.OutcomeSuccess)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+		tracker.capture = &bytes.Buffer{}
+	}
+
+	// The semantic cache is scoped per API key and model so answers never
+	// leak between clients or models, and per conversation context so only
+	// the last user message is compared
+	var embedding []float64
+	var semanticScope string
+	if services.IsSemanticCacheEnabled() && wantsSemanticCache(chatReq, r) {
+		semanticScope = requestKeyName(r) + "/" + primaryModel + "/" + semanticContext(chatReq)
+		if query := lastUserMessage(chatReq); query != "" {
+			embedCtx, embedCancel := context.WithTimeout(ctx, 15*time.Second)
+			embedding, err = services.EmbedText(embedCtx, query)
+			embedCancel()
+			if err != nil {
+				fmt.Printf("⚠️ Semantic cache lookup skipped: %v\n", err)
+			} else if cached, similarity, hit := services.LookupSemanticCache(semanticScope, embedding); hit {
+				fmt.Printf("🧠 Serving semantically cached response for %s (similarity %.3f)\n", primaryModel, similarity)
+				w.Header().Set("X-Semantic-Cache", "HIT")
+				w.Header().Set("X-Semantic-Similarity", fmt.Sprintf("%.4f", similarity))
+				serveCachedResponse(w, cached, chatReq, plan.responseModel())
+				services.RecordRequestOutcome(services.OutcomeSuccess)
+				return
+			} else {
+				w.Header().Set("X-Semantic-Cache", "MISS")
+				tracker.capture = &bytes.Buffer{}
+			}
+		}
+	}
+
+	if chatReq.Stream {
+		clearWriteDeadline(w)
+	}
+
+	// Streaming clients get heartbeats from the moment the request is
+	// dispatched, so the silence before the first token is covered too
+	if interval := services.GetStreamHeartbeatInterval(); chatReq.Stream && interval > 0 {
+		stopKeepAlive := tracker.keepAlive(interval)
+		defer stopKeepAlive()
+	}
+
+	var lastErr error
+
+	for i, model := range models {
+		if i > 0 {
+			if chatReq, err = prepareChatRequest(baseReq, model, plan, r, present); err != nil {
+				fmt.Printf("❌ Parameter policy of %s rejects the request: %v\n", model, err)
+				lastErr = err
+				continue
+			}
+		}
+		services.ClampMaxTokens(&chatReq, model)
+
+		// A fallback with a larger context window may still fit the prompt
+		if err := services.FitContextWindow(&chatReq, model, present["max_tokens"]); err != nil {
+			fmt.Printf("❌ Prompt does not fit %s: %v\n", model, err)
+			lastErr = err
+			continue
+		}
+
+		// Streams are synthesized from a complete response for models and
+		// routes that cannot stream, and aggregated into one for those that
+		// must always stream
+		upstreamReq := chatReq
+		if chatReq.Stream && (plan.DisableStreaming || services.StreamingDisabled(model)) {
+			fmt.Printf("📄 Requesting %s without streaming, the stream will be synthesized\n", model)
+			upstreamReq.Stream = false
+			upstreamReq.StreamOptions = nil
+		} else if !chatReq.Stream && (plan.ForceStreaming || services.StreamingForced(model)) {
+			fmt.Printf("📶 Requesting %s as a stream, the chunks will be aggregated\n", model)
+			upstreamReq.Stream = true
+			upstreamReq.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+		}
+
+		// Models that ignore n get one request per choice
+		fanOut := chatReq.N > 1 && (plan.FanOutN || services.FanOutEnabled(model))
+		if fanOut && chatReq.N > services.GetMaxFanOut() {
+			fmt.Printf("❌ n=%d exceeds the fan-out limit for %s\n", chatReq.N, model)
+			tracker.sendError(fmt.Sprintf("n must be at most %d for model '%s'", services.GetMaxFanOut(), plan.RequestedModel), "invalid_request_error", http.StatusBadRequest, "invalid_value")
+			return
+		}
+
+		var usage *streamUsage
+		if chatReq.WantsStreamUsage() || upstreamReq.Stream != chatReq.Stream {
+			usage = &streamUsage{PromptTokens: services.EstimateRequestTokens(chatReq), Requested: chatReq.WantsStreamUsage()}
+		}
+
+		data, err := json.Marshal(upstreamReq)
+		if err != nil {
+			fmt.Printf("❌ Failed to marshal request: %v\n", err)
+			tracker.sendError("Failed to marshal request", "internal_error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Served-Model", model)
+		if fanOut {
+			lastErr = dispatchFanOut(ctx, tracker, upstreamReq, model, chatReq.Stream, usage, plan.responseModel())
+		} else {
+			lastErr = dispatchChatRequest(ctx, tracker, data, model, chatReq.Stream, usage, plan.responseModel())
+		}
+		if lastErr == nil {
+			if model != primaryModel {
+				fmt.Printf("↪️ Served by fallback model %s instead of %s\n", model, primaryModel)
+			}
+			services.RecordServedModel(model, model != primaryModel)
+			services.RecordRequestOutcome(services.OutcomeSuccess)
+			if tracker.capture != nil {
+				if body, ok := capturedCompletion(tracker.capture.Bytes(), chatReq.Stream); ok {
+					cached := services.CachedResponse{Model: model, Body: body}
+					if cacheKey != "" {
+						services.StoreCachedResponse(cacheKey, cached)
+					}
+					if embedding != nil {
+						services.StoreSemanticCache(semanticScope, embedding, cached)
+					}
+				}
+			}
+			return
+		}
+
+		if ctx.Err() != nil {
+			abortChatRequest(tracker, r)
+			return
+		}
+		if tracker.Started() || !isRetryableError(lastErr) {
+			break
+		}
+		fmt.Printf("⚠️ Model %s failed with a retryable error, trying next fallback\n", model)
+	}
+
+	fmt.Printf("❌ All proxy attempts failed: %v\n", lastErr)
+	if errors.Is(lastErr, errStreamStalled) {
+		services.RecordRequestOutcome(services.OutcomeTimeout)
+	} else {
+		services.RecordRequestOutcome(services.OutcomeUpstreamError)
+	}
+	if tracker.Started() {
+		// Part of the response is already with the client; nothing more can be sent
+		return
+	}
+	w.Header().Del("X-Served-Model")
+	var policyErr *services.ForbiddenParameterError
+	if errors.As(lastErr, &policyErr) {
+		tracker.sendError(lastErr.Error(), "invalid_request_error", http.StatusBadRequest, "parameter_not_allowed")
+		return
+	}
+	if isContextLengthError(lastErr) {
+		tracker.sendError(lastErr.Error(), "invalid_request_error", http.StatusBadRequest, "context_length_exceeded")
+		return
+	}
+	tracker.sendError("Error: "+lastErr.Error(), "internal_error", http.StatusInternalServerError)
+}
+
+// prepareChatRequest returns the request as sent to model: with the
+// parameter policy and managed prompts resolved for the requested, routed
+// and serving model applied
+func prepareChatRequest(chatReq types.ChatCompletionRequest, model string, plan routePlan, r *http.Request, present map[string]bool) (types.ChatCompletionRequest, error) {
+	chatReq.Model = model
+	models := []string{plan.RequestedModel, plan.RoutedModel, model}
+
+	policy := services.GetParameterPolicy(models, requestKeyName(r))
+	if err := policy.Apply(&chatReq, present); err != nil {
+		return chatReq, err
+	}
+
+	templates := services.GetPromptTemplates(models, plan.Route, requestKeyName(r))
+	if len(templates) > 0 {
+		vars := services.PromptVariables{
+			Time:    time.Now(),
+			User:    chatReq.User,
+			KeyName: requestKeyName(r),
+			Model:   plan.RequestedModel,
+		}
+		optOut := strings.EqualFold(r.Header.Get("X-Skip-Prompt-Templates"), "true")
+		if applied := services.ApplyPromptTemplates(&chatReq, templates, vars, optOut); applied > 0 {
+			fmt.Printf("📝 Applied %d prompt templates for %s\n", applied, model)
+		}
+	}
+	return chatReq, nil
+}
+
+// dispatchChatRequest sends the marshalled request for model through up to
+// MaxProxyAttempts proxies, writing the first successful response to w. It
+// returns nil on success, the context error when ctx ends first, or the last
+// attempt error. A non-nil usage fills in usage the upstream did not report.
+func dispatchChatRequest(ctx context.Context, w *responseTracker, data []byte, model string, isStream bool, usage *streamUsage, responseModel string) error {
 	var lastErr error
 	usedProxies := make(map[string]bool)
 	var mu sync.Mutex
 	
-	fmt.Println("🔄 Beginning proxy attempts...")
+	fmt.Printf("🔄 Beginning proxy attempts for %s...\n", model)
 	
 	resultChan := make(chan bool, 1)
 	errChan := make(chan error, 1)
 	
-	for i := 0; i < services.MaxProxyAttempts && !success; i++ {
-		select {
-		case <-ctx.Done():
-			fmt.Println("⏱️ Request timeout")
-			utils.SendErrorResponse(w, "Request timeout", "timeout", http.StatusGatewayTimeout)
-			return
-		default:
-			proxy := services.GetWorkingProxy()
-			if proxy == "" {
-				fmt.Println("⚠️ No working proxy available, waiting for refresh...")
-				if i > 0 {
-					time.Sleep(500 * time.Millisecond)
-				}
-				continue
-			}
-			
-			mu.Lock()
-			if usedProxies[proxy] {
-				mu.Unlock()
-				continue
+	for i := 0; i < services.MaxProxyAttempts; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		proxy := services.GetWorkingProxy()
+		if proxy == "" {
+			fmt.Println("⚠️ No working proxy available, waiting for refresh...")
+			if i > 0 {
+				time.Sleep(500 * time.Millisecond)
 			}
-			usedProxies[proxy] = true
+			continue
+		}
+		
+		mu.Lock()
+		if usedProxies[proxy] {
 			mu.Unlock()
+			continue
+		}
+		usedProxies[proxy] = true
+		mu.Unlock()
 
-			fmt.Printf("🌐 Attempt %d: Using proxy %s\n", i+1, proxy)
-			
-			go func(p string, attemptNum int) {
-				result, err := sendChatRequest(ctx, p, services.DeepInfraBaseURL+services.ChatEndpoint, data, chatReq.Stream, w)
-				if err != nil {
+		fmt.Printf("🌐 Attempt %d: Using proxy %s\n", i+1, proxy)
+		
+		go func(p string, attemptNum int) {
+			result, err := sendChatRequest(ctx, p, services.DeepInfraBaseURL+services.ChatEndpoint, data, isStream, usage, responseModel, w)
+			if result || err != nil {
+				recordModelOutcome(model, err)
+			}
+			if err != nil {
+				// A cancelled context or a slow model stalling its stream
+				// says nothing about the proxy itself
+				if ctx.Err() == nil && blamesProxy(err) && !errors.Is(err, errStreamStalled) {
 					fmt.Printf("❌ Proxy attempt %d failed: %v\n", attemptNum, err)
 					services.RemoveProxy(p)
-					errChan <- err
-					return
 				}
-				
-				if result {
-					fmt.Printf("✅ Chat completion successful using proxy %s (attempt %d)\n", p, attemptNum)
-					resultChan <- true
-				} else {
-					errChan <- fmt.Errorf("proxy request failed without error")
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
 				}
-			}(proxy, i+1)
+				return
+			}
 			
-			select {
-			case result := <-resultChan:
-				if result {
-					success = true
-					break
+			if result {
+				fmt.Printf("✅ Chat completion successful using proxy %s (attempt %d)\n", p, attemptNum)
+				select {
+				case resultChan <- true:
+				case <-ctx.Done():
+				}
+			} else {
+				select {
+				case errChan <- fmt.Errorf("proxy request failed without error"):
+				case <-ctx.Done():
 				}
+			}
+		}(proxy, i+1)
+		
+	wait:
+		for {
+			select {
+			case <-resultChan:
+				return nil
 			case err := <-errChan:
 				lastErr = err
-				continue
+				// Other proxies reach the same upstream and would fail the same way
+				if w.Started() || isModelLevelError(err) {
+					return err
+				}
+				break wait
+			case <-ctx.Done():
+				return ctx.Err()
 			case <-time.After(10 * time.Second):
-				continue
+				// Once a response is being written to the client the attempt
+				// owns it; starting another one would interleave the output
+				if !w.Started() {
+					break wait
+				}
 			}
-			
-			if success {
-				break
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("Unable to process the request after multiple attempts")
+	}
+	return lastErr
+}
+
+// responseTracker records whether anything has been written to the client.
+// After that the request can no longer be retried or sent to a fallback.
+// When capture is set, the response body is also copied into it, until it
+// grows too large to be cached and capture is dropped. Heartbeats
+// sent by keepAlive do not count as output: once one has opened the stream
+// the request can still be retried, but failures are reported as error
+// events.
+type responseTracker struct {
+	http.ResponseWriter
+	started atomic.Bool
+	capture *bytes.Buffer
+
+	mu          sync.Mutex
+	wroteHeader bool
+	opened      bool
+	lastWrite   time.Time
+}
+
+func (t *responseTracker) WriteHeader(statusCode int) {
+	t.started.Store(true)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (t *responseTracker) Write(b []byte) (int, error) {
+	t.started.Store(true)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.wroteHeader = true
+	t.lastWrite = time.Now()
+	if t.capture != nil {
+		if int64(t.capture.Len()+len(b)) > services.GetResponseCacheMaxBytes() {
+			fmt.Printf("⚠️ Response too large to cache, no longer capturing it\n")
+			t.capture = nil
+		} else {
+			t.capture.Write(b)
+		}
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *responseTracker) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Started reports whether the response has begun
+func (t *responseTracker) Started() bool {
+	return t.started.Load()
+}
+
+// keepAlive sends an SSE comment whenever the response has been silent for
+// the interval, until the returned function is called. The first one sends
+// the stream headers.
+func (t *responseTracker) keepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.heartbeat(interval)
+			case <-done:
+				return
 			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
 	}
+}
 
-	if !success {
-		errMsg := "Unable to process the request after multiple attempts"
-		if lastErr != nil {
-			errMsg = "Error: " + lastErr.Error()
+func (t *responseTracker) heartbeat(interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastWrite) < interval {
+		return
+	}
+	if !t.wroteHeader {
+		t.Header().Set("Content-Type", "text/event-stream")
+		t.Header().Set("Cache-Control", "no-cache")
+		t.Header().Set("Connection", "keep-alive")
+		t.ResponseWriter.WriteHeader(http.StatusOK)
+		t.wroteHeader = true
+		t.opened = true
+	}
+	t.lastWrite = time.Now()
+	fmt.Fprint(t.ResponseWriter, ": keep-alive\n\n")
+	t.Flush()
+}
+
+// sendError reports a failure that ends the request: as an error response,
+// or as an error event once heartbeats have opened the stream
+func (t *responseTracker) sendError(message, errType string, statusCode int, code ...string) {
+	t.mu.Lock()
+	opened := t.opened
+	t.mu.Unlock()
+	if !opened {
+		utils.SendErrorResponse(t, message, errType, statusCode, code...)
+		return
+	}
+
+	var event types.OpenAIError
+	event.Error.Message = message
+	event.Error.Type = errType
+	if len(code) > 0 {
+		event.Error.Code = code[0]
+	}
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(t, "data: %s\n\n", data)
+	t.Flush()
+}
+
+// isModelLevelError reports whether DeepInfra itself rejected the request:
+// the model is missing or inaccessible, or the request is invalid. Other
+// proxies would get the same answer, so no further attempts are made.
+// Server errors are not included; they are often transient and worth
+// another attempt.
+func isModelLevelError(err error) bool {
+	if !isUpstreamResponse(err) {
+		return false
+	}
+
+	var apiErr *upstreamError
+	errors.As(err, &apiErr)
+	return apiErr.StatusCode == http.StatusBadRequest ||
+		apiErr.StatusCode == http.StatusUnauthorized ||
+		apiErr.StatusCode == http.StatusForbidden ||
+		apiErr.StatusCode == http.StatusNotFound ||
+		apiErr.StatusCode == http.StatusUnprocessableEntity ||
+		strings.Contains(apiErr.Body, "Not authenticated")
+}
+
+// isUpstreamResponse reports whether an error is an answer from DeepInfra,
+// which shows the proxy worked. Error pages produced by a broken proxy are
+// not JSON, which tells them apart.
+func isUpstreamResponse(err error) bool {
+	var apiErr *upstreamError
+	return errors.As(err, &apiErr) && json.Valid([]byte(apiErr.Body))
+}
+
+// blamesProxy reports whether a failed attempt shows the proxy is unusable:
+// it could not reach DeepInfra, or DeepInfra rate limited its address. Other
+// errors answered by DeepInfra are about the model or the request.
+func blamesProxy(err error) bool {
+	if !isUpstreamResponse(err) {
+		return true
+	}
+	var apiErr *upstreamError
+	errors.As(err, &apiErr)
+	return apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// isContextLengthError reports whether the prompt was too long for the
+// model, either by our own estimate or according to DeepInfra
+func isContextLengthError(err error) bool {
+	var contextErr *services.ContextLengthError
+	if errors.As(err, &contextErr) {
+		return true
+	}
+
+	var apiErr *upstreamError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	return strings.Contains(body, "context length") ||
+		strings.Contains(body, "context_length") ||
+		strings.Contains(body, "maximum context")
+}
+
+// isRetryableError reports whether a failed model is worth replacing with
+// its fallback: the model is overloaded, missing or failing upstream
+func isRetryableError(err error) bool {
+	var apiErr *upstreamError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusNotFound,
+		apiErr.StatusCode == http.StatusTooManyRequests,
+		apiErr.StatusCode >= http.StatusInternalServerError,
+		strings.Contains(apiErr.Body, "Not authenticated"):
+		return true
+	}
+	return false
+}
+
+// presentFields returns the top-level fields of a JSON request body that are
+// set to something other than null
+func presentFields(body []byte) map[string]bool {
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+
+	present := make(map[string]bool, len(fields))
+	for name, value := range fields {
+		if string(bytes.TrimSpace(value)) != "null" {
+			present[name] = true
 		}
-		fmt.Printf("❌ All proxy attempts failed: %s\n", errMsg)
-		utils.SendErrorResponse(w, errMsg, "internal_error", http.StatusInternalServerError)
+	}
+	return present
+}
+
+// abortChatRequest handles a request whose context ended before it
+// completed. A client disconnect is only logged since nobody is left to read
+// the response; an expired deadline is reported as a gateway timeout unless
+// part of the response has already been sent.
+func abortChatRequest(w *responseTracker, r *http.Request) {
+	if r.Context().Err() != nil {
+		fmt.Printf("🚫 Client %s disconnected, cancelling upstream request\n", r.RemoteAddr)
+		services.RecordRequestOutcome(services.OutcomeClientCancelled)
+		return
+	}
+
+	fmt.Println("⏱️ Request timeout")
+	services.RecordRequestOutcome(services.OutcomeTimeout)
+	if !w.Started() {
+		w.Header().Del("X-Served-Model")
+		w.sendError("Request timeout", "timeout", http.StatusGatewayTimeout)
 	}
 }
 
-func sendChatRequest(ctx context.Context, proxy, endpoint string, data []byte, isStream bool, w http.ResponseWriter) (bool, error) {
+// rewriteResponseModel replaces the "model" field of a completion or chunk
+// object. Bodies that are not JSON objects are returned unchanged.
+func rewriteResponseModel(body []byte, model string) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	if _, exists := fields["model"]; !exists {
+		return body
+	}
+
+	fields["model"], _ = json.Marshal(model)
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
+// sendChatRequest forwards the request through a proxy and writes the
+// upstream response to w. A non-empty model replaces the upstream model name
+// in the response.
+func sendChatRequest(ctx context.Context, proxy, endpoint string, data []byte, isStream bool, usage *streamUsage, model string, w http.ResponseWriter) (bool, error) {
 	proxyURL, err := url.Parse("http://" + proxy)
 	if err != nil {
 		return false, err
 	}
 
+	// Only the wait for the response headers is bounded here: a complete
+	// response is bounded by the request deadline and a stream by the stall
+	// timeout, so long generations are not cut off
 	client := &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
+			Proxy:                 http.ProxyURL(proxyURL),
+			ResponseHeaderTimeout: 60 * time.Second,
 		},
-		Timeout: 60 * time.Second,
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(data))
@@ -195,36 +705,180 @@ func sendChatRequest(ctx context.Context, proxy, endpoint string, data []byte, i
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
+		// Upstreams that cannot stream, or were asked not to, answer with a
+		// complete response
+		if isStream && !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			fmt.Println("📄 Upstream response is not streamed, synthesizing the stream")
+			return handleSynthesizedStreamResponse(w, resp, model, usage)
+		}
+		if !isStream && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			fmt.Println("📶 Upstream response is streamed, aggregating the chunks")
+			return handleAggregatedStreamResponse(w, resp, model, usage)
+		}
 		if isStream {
 			fmt.Println("📶 Handling streaming response")
-			return handleStreamResponse(w, resp)
+			return handleStreamResponse(w, resp, model, usage)
 		} else {
 			fmt.Println("📄 Handling normal response")
-			return handleNormalResponse(w, resp)
+			return handleNormalResponse(w, resp, model)
 		}
 	}
 
 	body, _ := io.ReadAll(resp.Body)
-	return false, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+	return false, &upstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+// upstreamError is a non-200 response from DeepInfra, as opposed to a
+// transport failure of the proxy
+type upstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Body)
+}
+
+// recordModelOutcome feeds the result of an attempt into the model
+// availability tracker. Only errors returned by DeepInfra itself say anything
+// about the model; proxy failures and client errors are ignored.
+func recordModelOutcome(model string, err error) {
+	if err == nil {
+		services.RecordModelSuccess(model)
+		return
+	}
+
+	var apiErr *upstreamError
+	if !errors.As(err, &apiErr) {
+		return
+	}
+
+	switch {
+	case strings.Contains(apiErr.Body, "Not authenticated"),
+		apiErr.StatusCode == http.StatusUnauthorized,
+		apiErr.StatusCode == http.StatusForbidden,
+		apiErr.StatusCode == http.StatusNotFound:
+		services.RecordModelFailure(model, true)
+	case apiErr.StatusCode >= http.StatusInternalServerError:
+		services.RecordModelFailure(model, false)
+	}
+}
+
+// streamUsage fills in usage the upstream did not report, estimating it with
+// the token counter: for streams whose client asked for it with
+// stream_options.include_usage, and for responses converted between streamed
+// and complete. On streams it watches the chunks as they pass. Requested
+// records whether a streaming client asked for usage; other streaming
+// clients never receive a usage chunk.
+type streamUsage struct {
+	PromptTokens int
+	Requested    bool
+
+	seen      bool
+	output    strings.Builder
+	lastChunk types.ChatCompletionChunk
+}
+
+// observe records a chunk payload sent to the client
+func (u *streamUsage) observe(payload string) {
+	var chunk types.ChatCompletionChunk
+	if json.Unmarshal([]byte(payload), &chunk) != nil {
+		return
+	}
+	if chunk.Usage != nil {
+		u.seen = true
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != nil {
+			u.output.WriteString(*choice.Delta.Content)
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			u.output.WriteString(call.Function.Name)
+			u.output.WriteString(call.Function.Arguments)
+		}
+	}
+	u.lastChunk = chunk
 }
 
-func handleStreamResponse(w http.ResponseWriter, resp *http.Response) (bool, error) {
+// estimate returns the usage of the completion so far from token counts
+func (u *streamUsage) estimate(output string) *types.Usage {
+	completionTokens := services.CountTokens(output)
+	return &types.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      u.PromptTokens + completionTokens,
+	}
+}
+
+// completionOutput returns the generated text of a completion for token
+// counting
+func completionOutput(completion types.ChatCompletionResponse) string {
+	var output strings.Builder
+	for _, choice := range completion.Choices {
+		output.WriteString(choice.Message.Content.String())
+		output.Write(choice.Message.ToolCalls)
+	}
+	return output.String()
+}
+
+// writeFinalChunk sends the usage chunk when the upstream sent none,
+// reporting whether it did
+func (u *streamUsage) writeFinalChunk(w io.Writer) bool {
+	if u.seen || u.lastChunk.ID == "" {
+		return false
+	}
+	u.seen = true
+	chunk := types.ChatCompletionChunk{
+		ID:      u.lastChunk.ID,
+		Object:  "chat.completion.chunk",
+		Created: u.lastChunk.Created,
+		Model:   u.lastChunk.Model,
+		Choices: []types.ChunkChoice{},
+		Usage:   u.estimate(u.output.String()),
+	}
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	fmt.Printf("🧮 Upstream sent no usage, estimated %d completion tokens\n", chunk.Usage.CompletionTokens)
+	return true
+}
+
+func handleStreamResponse(w http.ResponseWriter, resp *http.Response, model string, usage *streamUsage) (bool, error) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.WriteHeader(http.StatusOK)
 
-	scanner := bufio.NewScanner(resp.Body)
+	reader := newStreamReader(resp.Body)
+	defer reader.Close()
 	var buf bytes.Buffer
-	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	chunkCount := 0
+	var streamErr error
 	
-	for scanner.Scan() {
-		line := scanner.Text()
+	for {
+		line, ok, err := reader.Next()
+		if !ok {
+			streamErr = err
+			break
+		}
 		if line == "" {
 			continue
 		}
 		
+		if model != "" {
+			payload := strings.TrimPrefix(line, "data: ")
+			if payload != "[DONE]" {
+				line = "data: " + string(rewriteResponseModel([]byte(payload), model))
+			}
+		}
+		
+		if usage != nil {
+			if payload := strings.TrimPrefix(line, "data: "); payload == "[DONE]" {
+				usage.writeFinalChunk(w)
+			} else {
+				usage.observe(payload)
+			}
+		}
+		
 		if strings.HasPrefix(line, "data: ") {
 			fmt.Fprintf(w, "%s\n\n", line)
 		} else {
@@ -242,16 +896,28 @@ func handleStreamResponse(w http.ResponseWriter, resp *http.Response) (bool, err
 		chunkCount++
 	}
 	
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("❌ Stream error: %v\n", err)
-		return false, err
+	if errors.Is(streamErr, errStreamStalled) {
+		fmt.Printf("⏱️ Aborting stalled stream after %d chunks: %v\n", chunkCount, streamErr)
+		writeStreamError(w, "The upstream stopped responding: "+streamErr.Error(), "stream_stalled")
+		return false, streamErr
+	}
+	if streamErr != nil {
+		fmt.Printf("❌ Stream error: %v\n", streamErr)
+		return false, streamErr
+	}
+	
+	// Upstreams that end the stream without [DONE] still get the usage chunk
+	if usage != nil && usage.writeFinalChunk(w) {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
 	}
 	
 	fmt.Printf("✅ Stream complete, sent %d chunks\n", chunkCount)
 	return true, nil
 }
 
-func handleNormalResponse(w http.ResponseWriter, resp *http.Response) (bool, error) {
+func handleNormalResponse(w http.ResponseWriter, resp *http.Response, model string) (bool, error) {
 	w.Header().Set("Content-Type", "application/json")
 	
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -259,6 +925,10 @@ func handleNormalResponse(w http.ResponseWriter, resp *http.Response) (bool, err
 		return false, fmt.Errorf("failed to read response body: %v", err)
 	}
 	
+	if model != "" {
+		bodyBytes = rewriteResponseModel(bodyBytes, model)
+	}
+	
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(bodyBytes)
 	if err != nil {
@@ -268,4 +938,86 @@ func handleNormalResponse(w http.ResponseWriter, resp *http.Response) (bool, err
 	
 	fmt.Printf("✅ Response sent successfully (%d bytes)\n", len(bodyBytes))
 	return true, nil
-}
\ No newline at end of file
+}
+
+// handleSynthesizedStreamResponse answers a streamed request from a complete
+// upstream response, replayed as the chunks a stream would have produced
+func handleSynthesizedStreamResponse(w http.ResponseWriter, resp *http.Response, model string, usage *streamUsage) (bool, error) {
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var completion types.ChatCompletionResponse
+	if err := json.Unmarshal(bodyBytes, &completion); err != nil {
+		return false, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	if model != "" {
+		completion.Model = model
+	}
+	includeUsage := usage != nil && usage.Requested
+	if includeUsage && completion.Usage == nil {
+		completion.Usage = usage.estimate(completionOutput(completion))
+	}
+
+	chunkCount := writeCompletionStream(w, completion, includeUsage)
+	fmt.Printf("✅ Synthesized stream complete, sent %d chunks\n", chunkCount)
+	return true, nil
+}
+
+// handleAggregatedStreamResponse answers an unstreamed request from an
+// upstream stream, merging its chunks into one completion. Nothing is sent
+// until the stream has finished, so a stream that breaks off can still be
+// retried.
+func handleAggregatedStreamResponse(w http.ResponseWriter, resp *http.Response, model string, usage *streamUsage) (bool, error) {
+	var aggregator services.CompletionAggregator
+	reader := newStreamReader(resp.Body)
+	defer reader.Close()
+	chunkCount := 0
+
+	for {
+		line, ok, err := reader.Next()
+		if err != nil {
+			fmt.Printf("❌ Stream error: %v\n", err)
+			return false, err
+		}
+		if !ok {
+			break
+		}
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok || payload == "[DONE]" {
+			continue
+		}
+		var chunk types.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue
+		}
+		aggregator.Add(chunk)
+		chunkCount++
+	}
+	if !aggregator.Finished() {
+		return false, fmt.Errorf("upstream stream ended before the completion finished")
+	}
+
+	completion := aggregator.Completion()
+	if model != "" {
+		completion.Model = model
+	}
+	if usage != nil && completion.Usage == nil {
+		completion.Usage = usage.estimate(completionOutput(completion))
+	}
+	bodyBytes, err := json.Marshal(completion)
+	if err != nil {
+		return false, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(bodyBytes); err != nil {
+		fmt.Printf("❌ Error writing response: %v\n", err)
+		return false, err
+	}
+
+	fmt.Printf("✅ Aggregated %d chunks into one response (%d bytes)\n", chunkCount, len(bodyBytes))
+	return true, nil
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+// chatSocketMaxRequests bounds the requests in flight on one connection
+const chatSocketMaxRequests = 8
+
+// ChatWebSocketHandler serves chat completions over a WebSocket. Each
+// request frame runs through ChatCompletionsHandler as a streamed request,
+// so routing, fallbacks, policies and caching apply as usual, and its chunks
+// are sent back as frames. Several requests may run at once; a cancel frame
+// aborts one, including its upstream request.
+func ChatWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		fmt.Printf("❌ WebSocket upgrade failed: %v\n", err)
+		return
+	}
+	fmt.Printf("🔌 Chat WebSocket opened by %s\n", r.RemoteAddr)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	socket := &chatSocket{conn: conn, ctx: ctx, requests: make(map[string]context.CancelFunc)}
+	defer func() {
+		cancel()
+		socket.wg.Wait()
+		conn.Close(wsCloseNormal, "")
+		fmt.Printf("🔌 Chat WebSocket closed by %s\n", r.RemoteAddr)
+	}()
+
+	if interval := services.GetStreamHeartbeatInterval(); interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if conn.Ping() != nil {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) && !errors.Is(err, io.EOF) {
+				fmt.Printf("⚠️ Chat WebSocket read failed: %v\n", err)
+			}
+			return
+		}
+
+		var message types.ChatSocketMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			socket.sendError("", http.StatusBadRequest, "Failed to parse frame: "+err.Error(), "invalid_frame")
+			continue
+		}
+
+		switch message.Type {
+		case "request":
+			if message.Request == nil {
+				socket.sendError(message.ID, http.StatusBadRequest, "request frames need a request", "invalid_frame")
+				continue
+			}
+			socket.start(r, message.ID, *message.Request)
+		case "cancel":
+			socket.cancel(message.ID)
+		default:
+			socket.sendError(message.ID, http.StatusBadRequest, fmt.Sprintf("unknown frame type %q", message.Type), "invalid_frame")
+		}
+	}
+}
+
+// chatSocket tracks the requests running on one WebSocket connection
+type chatSocket struct {
+	conn *wsConn
+	ctx  context.Context
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	requests map[string]context.CancelFunc
+	nextID   int
+}
+
+func (s *chatSocket) send(message types.ChatSocketMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	s.conn.WriteText(data)
+}
+
+func (s *chatSocket) sendError(id string, status int, message, code string) {
+	s.send(types.ChatSocketMessage{
+		Type:  "error",
+		ID:    id,
+		Error: &types.ChatSocketError{Status: status, Message: message, Code: code},
+	})
+}
+
+// start runs a request in the background. Requests without an ID are given
+// one, which is echoed in every frame about them.
+func (s *chatSocket) start(r *http.Request, id string, chatReq types.ChatCompletionRequest) {
+	s.mu.Lock()
+	if id == "" {
+		s.nextID++
+		id = "req_" + strconv.Itoa(s.nextID)
+	}
+	if _, exists := s.requests[id]; exists {
+		s.mu.Unlock()
+		s.sendError(id, http.StatusConflict, "A request with this id is already running", "duplicate_id")
+		return
+	}
+	if len(s.requests) >= chatSocketMaxRequests {
+		s.mu.Unlock()
+		s.sendError(id, http.StatusTooManyRequests, fmt.Sprintf("At most %d requests may run at once on a connection", chatSocketMaxRequests), "too_many_requests")
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.requests[id] = cancel
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.requests, id)
+			s.mu.Unlock()
+			cancel()
+		}()
+		s.run(r.WithContext(ctx), id, chatReq)
+	}()
+}
+
+// cancel aborts a running request. The cancelled frame is sent once the
+// request has stopped, so no chunks follow it.
+func (s *chatSocket) cancel(id string) {
+	s.mu.Lock()
+	cancel, exists := s.requests[id]
+	s.mu.Unlock()
+	if !exists {
+		s.sendError(id, http.StatusNotFound, "No running request with this id", "not_found")
+		return
+	}
+	fmt.Printf("🛑 Cancelling WebSocket request %s\n", id)
+	cancel()
+}
+
+func (s *chatSocket) run(r *http.Request, id string, chatReq types.ChatCompletionRequest) {
+	ctx := r.Context()
+	chatReq.Stream = true
+
+	finished := false
+	stream := newChunkStreamWriter()
+	stream.OnChunk = func(chunk types.ChatCompletionChunk) {
+		if ctx.Err() != nil {
+			return
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				finished = true
+			}
+		}
+		s.send(types.ChatSocketMessage{Type: "chunk", ID: id, Chunk: &chunk})
+	}
+
+	runChatPipeline(stream, r, chatReq)
+
+	switch {
+	case ctx.Err() != nil:
+		if s.ctx.Err() == nil {
+			s.send(types.ChatSocketMessage{Type: "cancelled", ID: id})
+		}
+	case !stream.Streaming():
+		var apiErr types.OpenAIError
+		json.Unmarshal(stream.Body.Bytes(), &apiErr)
+		s.sendError(id, stream.Status(), pipelineErrorMessage(stream.Body.Bytes()), apiErr.Error.Code)
+	case stream.StreamError != "":
+		s.sendError(id, http.StatusBadGateway, stream.StreamError, "upstream_error")
+	case stream.Done() || finished:
+		// The stream may start with heartbeats before fallback settles, so
+		// the served model is only known once the pipeline has returned
+		s.send(types.ChatSocketMessage{Type: "done", ID: id, ServedModel: stream.Header().Get("X-Served-Model")})
+	default:
+		s.sendError(id, http.StatusBadGateway, "The upstream stream ended unexpectedly", "upstream_error")
+	}
+}
@@ -60,12 +60,75 @@ func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
     fmt.Printf("📄 Serving OpenAPI JSON for %s\n", r.RemoteAddr)
     
     models := services.GetSupportedModels()
+    for _, alias := range services.GetAliasModelInfo() {
+        models = append(models, alias.ID)
+    }
     
     modelEnum := make([]interface{}, len(models))
     for i, model := range models {
         modelEnum[i] = model
     }
 
+    modelsQueryParameters := []map[string]interface{}{
+        {
+            "name":        "type",
+            "in":          "query",
+            "description": "Only return models of this type",
+            "schema": map[string]interface{}{
+                "type": "string",
+                "enum": services.ModelTypes,
+            },
+        },
+        {
+            "name":        "owner",
+            "in":          "query",
+            "description": "Only return models from this organization, e.g. meta-llama",
+            "schema": map[string]interface{}{
+                "type": "string",
+            },
+        },
+        {
+            "name":        "capabilities",
+            "in":          "query",
+            "description": "Comma-separated capabilities the model must support (tools, vision, json_mode)",
+            "schema": map[string]interface{}{
+                "type": "string",
+            },
+        },
+        {
+            "name":        "min_context_length",
+            "in":          "query",
+            "description": "Only return models with at least this context length",
+            "schema": map[string]interface{}{
+                "type": "integer",
+            },
+        },
+        {
+            "name":        "q",
+            "in":          "query",
+            "description": "Case-insensitive substring match on the model ID and description",
+            "schema": map[string]interface{}{
+                "type": "string",
+            },
+        },
+        {
+            "name":        "after",
+            "in":          "query",
+            "description": "Return models after this model ID",
+            "schema": map[string]interface{}{
+                "type": "string",
+            },
+        },
+        {
+            "name":        "limit",
+            "in":          "query",
+            "description": "Maximum number of models to return (1-1000)",
+            "schema": map[string]interface{}{
+                "type": "integer",
+            },
+        },
+    }
+
     securitySchemes := map[string]interface{}{}
     security := []map[string]interface{}{}
     
@@ -137,6 +200,7 @@ func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
                 "get": map[string]interface{}{
                     "summary":     "List available models (OpenAI compatible)",
                     "operationId": "listModelsV1",
+                    "parameters":  modelsQueryParameters,
                     "responses": map[string]interface{}{
                         "200": map[string]interface{}{
                             "description": "Successful response",
@@ -154,10 +218,50 @@ func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
                     },
                 },
             },
+            "/v1/models/{id}": map[string]interface{}{
+                "get": map[string]interface{}{
+                    "summary":     "Retrieve a model (OpenAI compatible)",
+                    "operationId": "retrieveModelV1",
+                    "parameters": []map[string]interface{}{
+                        {
+                            "name":     "id",
+                            "in":       "path",
+                            "required": true,
+                            "schema": map[string]interface{}{
+                                "type": "string",
+                            },
+                        },
+                        {
+                            "name":        "extended",
+                            "in":          "query",
+                            "description": "Return full model metadata instead of the OpenAI model object",
+                            "schema": map[string]interface{}{
+                                "type": "boolean",
+                            },
+                        },
+                    },
+                    "responses": map[string]interface{}{
+                        "200": map[string]interface{}{
+                            "description": "Successful response",
+                            "content": map[string]interface{}{
+                                "application/json": map[string]interface{}{
+                                    "schema": map[string]interface{}{
+                                        "$ref": "#/components/schemas/OpenAIModel",
+                                    },
+                                },
+                            },
+                        },
+                        "404": map[string]interface{}{
+                            "description": "Model not found",
+                        },
+                    },
+                },
+            },
             "/models": map[string]interface{}{
                 "get": map[string]interface{}{
                     "summary":     "List available models",
                     "operationId": "listModels",
+                    "parameters":  modelsQueryParameters,
                     "responses": map[string]interface{}{
                         "200": map[string]interface{}{
                             "description": "Successful response",
@@ -204,12 +308,10 @@ func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
                             "format": "float",
                             "minimum": 0,
                             "maximum": 2,
-                            "default": 0.7,
                         },
                         "max_tokens": map[string]interface{}{
                             "type": "integer",
                             "minimum": 1,
-                            "default": 15000,
                         },
                     },
                 },
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"deepinfra-wrapper/types"
+)
+
+// dispatchFanOutRequest sends one request of a fan-out. Tests replace it to
+// stand in for the upstream.
+var dispatchFanOutRequest = dispatchChatRequest
+
+// dispatchFanOut serves a request for n choices to a model that ignores n.
+// n single-choice requests are sent in parallel and their responses merged
+// into one, each choice at its own index. Streams are merged chunk by chunk
+// as they arrive. The response is all or nothing: when one request fails
+// the others are cancelled and its error is returned.
+func dispatchFanOut(ctx context.Context, w *responseTracker, upstreamReq types.ChatCompletionRequest, model string, isStream bool, usage *streamUsage, responseModel string) error {
+	n := upstreamReq.N
+	bodies, err := fanOutBodies(upstreamReq)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔱 Fanning out %d requests to %s\n", n, model)
+
+	fanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	merger := &fanOutMerger{w: w, isStream: isStream, includeUsage: usage != nil && usage.Requested, bodies: make([][]byte, n)}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			var branchUsage *streamUsage
+			if usage != nil {
+				branchUsage = &streamUsage{PromptTokens: usage.PromptTokens, Requested: usage.Requested}
+			}
+			branch := &responseTracker{ResponseWriter: &fanOutBranch{merger: merger, index: index, header: make(http.Header)}}
+			if err := dispatchFanOutRequest(fanCtx, branch, bodies[index], model, isStream, branchUsage, responseModel); err != nil {
+				errs[index] = err
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := firstFanOutError(errs); err != nil {
+		fmt.Printf("❌ Fanned out request failed: %v\n", err)
+		merger.fail(err)
+		return err
+	}
+	return merger.finish()
+}
+
+// fanOutBodies returns the single-choice requests of a fan-out. When the
+// request has a seed, each request gets its own, counting up from it, so
+// that the choices differ.
+func fanOutBodies(upstreamReq types.ChatCompletionRequest) ([][]byte, error) {
+	bodies := make([][]byte, upstreamReq.N)
+	upstreamReq.N = 0
+	for i := range bodies {
+		branchReq := upstreamReq
+		if upstreamReq.Seed != nil {
+			seed := *upstreamReq.Seed + int64(i)
+			branchReq.Seed = &seed
+		}
+		data, err := json.Marshal(branchReq)
+		if err != nil {
+			return nil, err
+		}
+		bodies[i] = data
+	}
+	return bodies, nil
+}
+
+// firstFanOutError returns the error that ended a fanned out request,
+// preferring the failure itself over the cancellations it caused
+func firstFanOutError(errs []error) error {
+	var cancelled error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		cancelled = err
+	}
+	return cancelled
+}
+
+// fanOutMerger combines the responses of the requests of a fan-out. Streamed
+// chunks are passed on with their choice index and a shared id, while usage
+// is added up and sent in one final chunk when the client asked for it.
+// Complete responses are kept until all requests are done.
+type fanOutMerger struct {
+	w            *responseTracker
+	isStream     bool
+	includeUsage bool
+
+	mu        sync.Mutex
+	started   bool
+	errorSent bool
+	id        string
+	created   int64
+	model     string
+	usage     *types.Usage
+	bodies    [][]byte
+}
+
+// addUsage adds the usage of one request. The prompt is the same for all of
+// them, so it is counted once.
+func (m *fanOutMerger) addUsage(raw json.RawMessage) {
+	var usage types.Usage
+	if json.Unmarshal(raw, &usage) != nil {
+		return
+	}
+	if m.usage == nil {
+		m.usage = &types.Usage{PromptTokens: usage.PromptTokens}
+	}
+	m.usage.CompletionTokens += usage.CompletionTokens
+	m.usage.TotalTokens = m.usage.PromptTokens + m.usage.CompletionTokens
+}
+
+// forward passes one stream event of the request at index to the client
+func (m *fanOutMerger) forward(index int, event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	payload, isData := strings.CutPrefix(event, "data: ")
+	switch {
+	case !isData:
+		// Heartbeats are sent once for the whole response by w
+		return
+	case payload == "[DONE]":
+		return
+	case strings.HasPrefix(payload, `{"error"`):
+		m.errorSent = true
+	default:
+		rewritten, ok := m.rewriteChunk(index, []byte(payload))
+		if !ok {
+			return
+		}
+		event = "data: " + string(rewritten)
+	}
+
+	m.start()
+	fmt.Fprintf(m.w, "%s\n\n", event)
+	m.w.Flush()
+}
+
+// rewriteChunk gives a chunk the shared id and its choices the index of
+// their request, taking out usage to be merged. Usage-only chunks are
+// dropped.
+func (m *fanOutMerger) rewriteChunk(index int, payload []byte) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return payload, true
+	}
+
+	var chunk types.ChatCompletionChunk
+	json.Unmarshal(payload, &chunk)
+	if m.id == "" {
+		m.id, m.created = chunk.ID, chunk.Created
+	}
+	if chunk.Model != "" {
+		m.model = chunk.Model
+	}
+	if usage, exists := fields["usage"]; exists {
+		if string(usage) != "null" {
+			m.addUsage(usage)
+		}
+		delete(fields, "usage")
+	}
+
+	choices, ok := reindexChoices(fields["choices"], index)
+	if !ok || len(choices) == 0 {
+		return nil, false
+	}
+	fields["choices"], _ = json.Marshal(choices)
+	fields["id"], _ = json.Marshal(m.id)
+
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return payload, true
+	}
+	return rewritten, true
+}
+
+// reindexChoices sets the index of each choice, keeping the rest of its
+// fields as they are
+func reindexChoices(raw json.RawMessage, index int) ([]map[string]json.RawMessage, bool) {
+	var choices []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &choices); err != nil {
+		return nil, false
+	}
+	for _, choice := range choices {
+		choice["index"], _ = json.Marshal(index)
+	}
+	return choices, true
+}
+
+// start sends the stream headers once
+func (m *fanOutMerger) start() {
+	if m.started {
+		return
+	}
+	m.started = true
+	m.w.Header().Set("Content-Type", "text/event-stream")
+	m.w.Header().Set("Cache-Control", "no-cache")
+	m.w.Header().Set("Connection", "keep-alive")
+	m.w.WriteHeader(http.StatusOK)
+}
+
+// fail ends a stream that has already started with an error event, unless
+// the failed request sent one itself
+func (m *fanOutMerger) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isStream || !m.started || m.errorSent {
+		return
+	}
+	writeStreamError(m.w, "One of the fanned out requests failed: "+err.Error(), "upstream_error")
+}
+
+// finish completes the response once every request has succeeded
+func (m *fanOutMerger) finish() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isStream {
+		m.start()
+		if m.usage != nil && m.includeUsage {
+			chunk := types.ChatCompletionChunk{
+				ID:      m.id,
+				Object:  "chat.completion.chunk",
+				Created: m.created,
+				Model:   m.model,
+				Choices: []types.ChunkChoice{},
+				Usage:   m.usage,
+			}
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(m.w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(m.w, "data: [DONE]\n\n")
+		m.w.Flush()
+		fmt.Printf("✅ Merged %d fanned out streams\n", len(m.bodies))
+		return nil
+	}
+
+	var merged map[string]json.RawMessage
+	var choices []map[string]json.RawMessage
+	for index, body := range m.bodies {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return fmt.Errorf("failed to parse fanned out response: %v", err)
+		}
+		if merged == nil {
+			merged = fields
+		}
+		if usage, exists := fields["usage"]; exists && string(usage) != "null" {
+			m.addUsage(usage)
+		}
+		branchChoices, ok := reindexChoices(fields["choices"], index)
+		if !ok {
+			return fmt.Errorf("fanned out response has no valid choices")
+		}
+		choices = append(choices, branchChoices...)
+	}
+	merged["choices"], _ = json.Marshal(choices)
+	if m.usage != nil {
+		merged["usage"], _ = json.Marshal(m.usage)
+	}
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	m.w.Header().Set("Content-Type", "application/json")
+	m.w.WriteHeader(http.StatusOK)
+	if _, err := m.w.Write(body); err != nil {
+		fmt.Printf("❌ Error writing response: %v\n", err)
+		return err
+	}
+	fmt.Printf("✅ Merged %d fanned out responses (%d bytes)\n", len(m.bodies), len(body))
+	return nil
+}
+
+// fanOutBranch is the response writer of one request of a fan-out. Stream
+// events go to the merger as soon as they are complete; a complete response
+// is kept for the merger to combine.
+type fanOutBranch struct {
+	merger *fanOutMerger
+	index  int
+	header http.Header
+	buf    bytes.Buffer
+}
+
+func (b *fanOutBranch) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader is a no-op: the merger sends the headers of the combined
+// response
+func (b *fanOutBranch) WriteHeader(statusCode int) {}
+
+func (b *fanOutBranch) Write(data []byte) (int, error) {
+	b.buf.Write(data)
+	if !b.merger.isStream {
+		b.merger.mu.Lock()
+		b.merger.bodies[b.index] = b.buf.Bytes()
+		b.merger.mu.Unlock()
+		return len(data), nil
+	}
+
+	for {
+		event, rest, found := bytes.Cut(b.buf.Bytes(), []byte("\n\n"))
+		if !found {
+			break
+		}
+		b.merger.forward(b.index, string(event))
+		remaining := append([]byte(nil), rest...)
+		b.buf.Reset()
+		b.buf.Write(remaining)
+	}
+	return len(data), nil
+}
+
+// Flush is a no-op: the merger flushes after each event it passes on
+func (b *fanOutBranch) Flush() {}
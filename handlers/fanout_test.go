@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deepinfra-wrapper/types"
+)
+
+// fakeFanOutUpstream stands in for the upstream of a fan-out. Each request
+// answers with its seed, from which the request's index is known.
+func fakeFanOutUpstream(t *testing.T, respond func(ctx context.Context, w *responseTracker, seed int64, isStream bool) error) {
+	t.Helper()
+	previous := dispatchFanOutRequest
+	dispatchFanOutRequest = func(ctx context.Context, w *responseTracker, data []byte, model string, isStream bool, usage *streamUsage, responseModel string) error {
+		var req types.ChatCompletionRequest
+		if err := json.Unmarshal(data, &req); err != nil || req.Seed == nil {
+			return fmt.Errorf("fanned out request without a seed: %s", data)
+		}
+		if req.N != 0 {
+			return fmt.Errorf("fanned out request still asks for %d choices", req.N)
+		}
+		return respond(ctx, w, *req.Seed, isStream)
+	}
+	t.Cleanup(func() { dispatchFanOutRequest = previous })
+}
+
+func fanOutRequest(n int, seed int64) types.ChatCompletionRequest {
+	return types.ChatCompletionRequest{
+		Model:    "test/model",
+		Messages: []types.ChatMessage{{Role: "user", Content: types.TextContent("Hello")}},
+		N:        n,
+		Seed:     &seed,
+	}
+}
+
+// streamEvents returns the data payloads and comments sent to the client
+func streamEvents(body string) (payloads []string, comments int) {
+	for _, event := range strings.Split(body, "\n\n") {
+		switch {
+		case strings.HasPrefix(event, "data: "):
+			payloads = append(payloads, strings.TrimPrefix(event, "data: "))
+		case strings.HasPrefix(event, ":"):
+			comments++
+		}
+	}
+	return payloads, comments
+}
+
+func TestFanOutBodies(t *testing.T) {
+	bodies, err := fanOutBodies(fanOutRequest(3, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range bodies {
+		var req types.ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		if req.Seed == nil || *req.Seed != int64(7+i) || req.N != 0 {
+			t.Errorf("request %d = %s, want seed %d and no n", i, body, 7+i)
+		}
+	}
+
+	unseeded := fanOutRequest(2, 0)
+	unseeded.Seed = nil
+	bodies, _ = fanOutBodies(unseeded)
+	for i, body := range bodies {
+		if strings.Contains(string(body), `"seed"`) {
+			t.Errorf("request %d = %s, want no seed", i, body)
+		}
+	}
+}
+
+func TestFanOutMergesStreams(t *testing.T) {
+	for _, requested := range []bool{false, true} {
+		t.Run(fmt.Sprintf("include_usage=%v", requested), func(t *testing.T) {
+			fakeFanOutUpstream(t, func(ctx context.Context, w *responseTracker, seed int64, isStream bool) error {
+				fmt.Fprintf(w, ": keep-alive\n\n")
+				fmt.Fprintf(w, `data: {"id":"chatcmpl-%d","object":"chat.completion.chunk","created":1,"model":"test/model","choices":[{"index":0,"delta":{"content":"seed %d"},"finish_reason":null}]}`+"\n\n", seed, seed)
+				fmt.Fprintf(w, `data: {"id":"chatcmpl-%d","object":"chat.completion.chunk","created":1,"model":"test/model","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\n", seed)
+				fmt.Fprintf(w, `data: {"id":"chatcmpl-%d","object":"chat.completion.chunk","created":1,"model":"test/model","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":%d,"total_tokens":%d}}`+"\n\n", seed, seed, 10+seed)
+				fmt.Fprint(w, "data: [DONE]\n\n")
+				return nil
+			})
+
+			recorder := httptest.NewRecorder()
+			tracker := &responseTracker{ResponseWriter: recorder}
+			usage := &streamUsage{PromptTokens: 10, Requested: requested}
+			if err := dispatchFanOut(context.Background(), tracker, fanOutRequest(3, 1), "test/model", true, usage, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			payloads, comments := streamEvents(recorder.Body.String())
+			if comments != 0 {
+				t.Errorf("%d comments from the fanned out requests reached the client", comments)
+			}
+			if payloads[len(payloads)-1] != "[DONE]" {
+				t.Errorf("stream ends with %q, want [DONE]", payloads[len(payloads)-1])
+			}
+
+			ids := make(map[string]bool)
+			contents := make(map[int]string)
+			var merged *types.Usage
+			for _, payload := range payloads[:len(payloads)-1] {
+				var chunk types.ChatCompletionChunk
+				if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+					t.Fatalf("invalid chunk %q: %v", payload, err)
+				}
+				ids[chunk.ID] = true
+				if chunk.Usage != nil {
+					if len(chunk.Choices) != 0 {
+						t.Errorf("usage chunk %s has choices", payload)
+					}
+					merged = chunk.Usage
+				}
+				for _, choice := range chunk.Choices {
+					if choice.Delta.Content != nil {
+						contents[choice.Index] += *choice.Delta.Content
+					}
+				}
+			}
+
+			if len(ids) != 1 {
+				t.Errorf("chunks carry ids %v, want one shared id", ids)
+			}
+			// Each choice index holds the answer of the request with the
+			// matching seed
+			for index := 0; index < 3; index++ {
+				if want := fmt.Sprintf("seed %d", 1+index); contents[index] != want {
+					t.Errorf("choice %d = %q, want %q", index, contents[index], want)
+				}
+			}
+			switch {
+			case !requested && merged != nil:
+				t.Errorf("usage chunk %+v sent although it was not requested", merged)
+			case requested && merged == nil:
+				t.Error("no usage chunk although it was requested")
+			case requested && (merged.PromptTokens != 10 || merged.CompletionTokens != 6 || merged.TotalTokens != 16):
+				t.Errorf("usage = %+v, want the prompt once and the completions summed", merged)
+			}
+		})
+	}
+}
+
+func TestFanOutMergesCompleteResponses(t *testing.T) {
+	fakeFanOutUpstream(t, func(ctx context.Context, w *responseTracker, seed int64, isStream bool) error {
+		fmt.Fprintf(w, `{"id":"chatcmpl-%d","object":"chat.completion","created":1,"model":"test/model","choices":[{"index":0,"message":{"role":"assistant","content":"seed %d"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":%d,"total_tokens":%d}}`, seed, seed, seed, 10+seed)
+		return nil
+	})
+
+	recorder := httptest.NewRecorder()
+	tracker := &responseTracker{ResponseWriter: recorder}
+	if err := dispatchFanOut(context.Background(), tracker, fanOutRequest(2, 5), "test/model", false, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var completion types.ChatCompletionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &completion); err != nil {
+		t.Fatalf("invalid merged response %q: %v", recorder.Body.String(), err)
+	}
+	if len(completion.Choices) != 2 {
+		t.Fatalf("merged %d choices, want 2", len(completion.Choices))
+	}
+	for i, choice := range completion.Choices {
+		if want := fmt.Sprintf("seed %d", 5+i); choice.Index != i || choice.Message.Content.String() != want {
+			t.Errorf("choice %d = index %d %q, want index %d %q", i, choice.Index, choice.Message.Content.String(), i, want)
+		}
+	}
+	if usage := completion.Usage; usage == nil || usage.PromptTokens != 10 || usage.CompletionTokens != 11 || usage.TotalTokens != 21 {
+		t.Errorf("usage = %+v, want 10 prompt and 11 completion tokens", usage)
+	}
+}
+
+func TestFanOutBranchFailure(t *testing.T) {
+	failure := errors.New("upstream rejected the request")
+
+	for _, isStream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", isStream), func(t *testing.T) {
+			started := make(chan struct{})
+			fakeFanOutUpstream(t, func(ctx context.Context, w *responseTracker, seed int64, isStream bool) error {
+				if seed == 1 {
+					// Fail once the first request has sent a chunk
+					<-started
+					return failure
+				}
+				if isStream {
+					fmt.Fprintf(w, `data: {"id":"chatcmpl-0","object":"chat.completion.chunk","created":1,"model":"test/model","choices":[{"index":0,"delta":{"content":"partial"},"finish_reason":null}]}`+"\n\n")
+				}
+				close(started)
+				<-ctx.Done()
+				return ctx.Err()
+			})
+
+			recorder := httptest.NewRecorder()
+			tracker := &responseTracker{ResponseWriter: recorder}
+			err := dispatchFanOut(context.Background(), tracker, fanOutRequest(2, 0), "test/model", isStream, nil, "")
+			if !errors.Is(err, failure) {
+				t.Fatalf("dispatchFanOut() error = %v, want the failed request's error", err)
+			}
+
+			payloads, _ := streamEvents(recorder.Body.String())
+			if !isStream {
+				if tracker.Started() {
+					t.Errorf("a failed fan-out wrote %q, so it cannot be retried", recorder.Body.String())
+				}
+				return
+			}
+			last := payloads[len(payloads)-1]
+			if !strings.HasPrefix(last, `{"error"`) || !strings.Contains(last, failure.Error()) {
+				t.Errorf("stream ends with %q, want an error event", last)
+			}
+			for _, payload := range payloads {
+				if payload == "[DONE]" {
+					t.Error("a failed stream was completed with [DONE]")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+// LivenessHandler reports that the process is up and serving HTTP
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// ReadinessHandler reports ready only once the model catalog is loaded and
+// at least one upstream route is available
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	status := buildStatus()
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("NOT READY"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// StatusHandler returns a detailed JSON view of the service state
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := buildStatus()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func buildStatus() types.StatusResponse {
+	now := time.Now()
+
+	catalog := types.CatalogStatus{
+		Models: services.GetModelCount(),
+	}
+	if lastUpdate := services.GetLastModelsUpdate(); !lastUpdate.IsZero() {
+		catalog.LastRefresh = lastUpdate.Unix()
+		catalog.AgeSeconds = int64(now.Sub(lastUpdate).Seconds())
+	}
+	catalog.Loaded = catalog.Models > 0
+	catalog.Stale = services.IsCatalogStale()
+	catalog.Availability = services.GetAvailabilityCounts()
+
+	upstream := types.UpstreamStatus{
+		Name:           "deepinfra",
+		WorkingProxies: services.GetProxyCount(),
+		Models:         modelBreakerStates(),
+	}
+	upstream.Healthy = upstream.WorkingProxies > 0
+	if lastUpdate := services.GetLastProxyUpdate(); !lastUpdate.IsZero() {
+		upstream.LastRefresh = lastUpdate.Unix()
+	}
+	for _, model := range upstream.Models {
+		if model.Breaker == services.BreakerOpen {
+			upstream.OpenModels++
+		}
+	}
+
+	ready := catalog.Loaded && upstream.Healthy
+	state := "ok"
+	if !ready {
+		state = "degraded"
+	}
+
+	servedModels, fallbacks := services.GetServedModels()
+
+	var cache *types.CacheStatus
+	if services.IsResponseCacheEnabled() {
+		hits, misses, entries := services.GetCacheStats()
+		cache = &types.CacheStatus{Hits: hits, Misses: misses, Entries: entries}
+	}
+	var semanticCache *types.CacheStatus
+	if services.IsSemanticCacheEnabled() {
+		hits, misses, entries := services.GetSemanticCacheStats()
+		semanticCache = &types.CacheStatus{Hits: hits, Misses: misses, Entries: entries}
+	}
+
+	return types.StatusResponse{
+		Status:        state,
+		Ready:         ready,
+		Version:       services.Version,
+		UptimeSeconds: int64(now.Sub(services.StartTime).Seconds()),
+		Catalog:       catalog,
+		Upstreams:     []types.UpstreamStatus{upstream},
+		QueueDepth:    len(chatSemaphore),
+		QueueCapacity: cap(chatSemaphore),
+		Requests:      services.GetRequestOutcomes(),
+		ServedModels:  servedModels,
+		Fallbacks:     fallbacks,
+		Cache:         cache,
+		SemanticCache: semanticCache,
+	}
+}
+
+// modelBreakerStates lists the tracked availability of the upstream models,
+// sorted by model ID
+func modelBreakerStates() []types.ModelBreakerState {
+	tracked := services.GetTrackedAvailability()
+	states := make([]types.ModelBreakerState, 0, len(tracked))
+	for model, state := range tracked {
+		status := state.Status
+		if status == "" {
+			status = services.AvailabilityUnknown
+		}
+		states = append(states, types.ModelBreakerState{
+			Model:               model,
+			Status:              status,
+			Breaker:             services.BreakerState(state),
+			ConsecutiveFailures: state.ConsecutiveFailures,
+			LastSuccess:         state.LastSuccess,
+			LastFailure:         state.LastFailure,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Model < states[j].Model })
+	return states
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,14 +12,21 @@ import (
 
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		apiKey := services.GetAPIKey()
-		if apiKey == "" {
+		if !services.IsAuthEnabled() {
 			fmt.Println("🔓 No API key set, skipping authentication")
 			next(w, r)
 			return
 		}
 
 		auth := r.Header.Get("Authorization")
+		if auth == "" && r.Header.Get("X-Api-Key") != "" {
+			// Anthropic clients send the key in x-api-key
+			auth = "Bearer " + r.Header.Get("X-Api-Key")
+		}
+		if auth == "" && r.URL.Query().Get("api_key") != "" && headerContainsToken(r.Header, "Upgrade", "websocket") {
+			// Browsers cannot set headers on WebSocket connections
+			auth = "Bearer " + r.URL.Query().Get("api_key")
+		}
 		if auth == "" {
 			fmt.Println("❌ Authentication failed: Missing API key")
 			utils.SendErrorResponse(w, "Missing API key", "invalid_request_error", http.StatusUnauthorized, "invalid_api_key")
@@ -33,13 +41,25 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		providedKey := strings.TrimPrefix(auth, bearerPrefix)
-		if providedKey != apiKey {
+		keyName, ok := services.AuthenticateKey(providedKey)
+		if !ok {
 			fmt.Println("❌ Authentication failed: Invalid API key")
 			utils.SendErrorResponse(w, "Invalid API key", "invalid_request_error", http.StatusUnauthorized, "invalid_api_key")
 			return
 		}
 
-		fmt.Println("✅ Authentication successful")
-		next(w, r)
+		fmt.Printf("✅ Authentication successful (key: %s)\n", keyName)
+		next(w, r.WithContext(context.WithValue(r.Context(), keyNameContextKey, keyName)))
 	}
+}
+
+type contextKey int
+
+const keyNameContextKey contextKey = iota
+
+// requestKeyName returns the name of the API key that authenticated the
+// request, or "" when authentication is disabled
+func requestKeyName(r *http.Request) string {
+	name, _ := r.Context().Value(keyNameContextKey).(string)
+	return name
 }
\ No newline at end of file
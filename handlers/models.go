@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"deepinfra-wrapper/services"
 	"deepinfra-wrapper/types"
+	"deepinfra-wrapper/utils"
 )
 
 func ModelsHandler(w http.ResponseWriter, r *http.Request) {
@@ -16,8 +19,28 @@ func ModelsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	fmt.Printf("📋 Handling models request from %s\n", r.RemoteAddr)
-	models := services.GetSupportedModels()
+	query, err := parseModelsQuery(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	
+	var supported []services.ModelInfo
+	for _, modelInfo := range catalogWithAliases() {
+		if modelInfo.Availability == nil || modelInfo.Availability.Status != services.AvailabilityUnavailable {
+			supported = append(supported, modelInfo)
+		}
+	}
+	page, hasMore := query.apply(supported)
+	
+	models := make([]string, len(page))
+	for i, modelInfo := range page {
+		models[i] = modelInfo.ID
+	}
 	
+	// The body is a bare array, so whether more pages remain is sent as a
+	// header
+	w.Header().Set("X-Has-More", strconv.FormatBool(hasMore))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(models)
@@ -32,27 +55,170 @@ func OpenAIModelsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	fmt.Printf("📋 Handling OpenAI-compatible models request from %s\n", r.RemoteAddr)
-	modelInfos := services.GetAllModelInfo()
+	query, err := parseModelsQuery(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	
+	modelInfos, hasMore := query.apply(catalogWithAliases())
+	firstID, lastID := "", ""
+	if len(modelInfos) > 0 {
+		firstID = modelInfos[0].ID
+		lastID = modelInfos[len(modelInfos)-1].ID
+	}
+	
+	if isExtendedView(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(extendedModelsResponse{
+			Object:  "list",
+			Data:    modelInfos,
+			FirstID: firstID,
+			LastID:  lastID,
+			HasMore: hasMore,
+		})
+		fmt.Printf("✅ Returned %d models in extended format\n", len(modelInfos))
+		return
+	}
 	
 	// Convert to OpenAI-compatible format
 	openAIModels := make([]types.OpenAIModel, len(modelInfos))
 	
 	for i, modelInfo := range modelInfos {
-		openAIModels[i] = types.OpenAIModel{
-			ID:      modelInfo.ID,
-			Object:  "model",
-			Created: modelInfo.Created,
-			OwnedBy: modelInfo.OwnedBy,
-		}
+		openAIModels[i] = toOpenAIModel(modelInfo)
 	}
 	
 	response := types.OpenAIModelsResponse{
-		Object: "list",
-		Data:   openAIModels,
+		Object:  "list",
+		Data:    openAIModels,
+		FirstID: firstID,
+		LastID:  lastID,
+		HasMore: hasMore,
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 	fmt.Printf("✅ Returned %d models in OpenAI format\n", len(modelInfos))
-}
\ No newline at end of file
+}
+
+// OpenAI-compatible /v1/models/{id} endpoint
+func OpenAIModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	modelID := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+	fmt.Printf("📋 Handling model request for %s from %s\n", modelID, r.RemoteAddr)
+	
+	modelInfo, exists := services.GetModelInfo(modelID)
+	if !exists {
+		modelInfo, exists = services.GetAliasInfo(modelID)
+	}
+	if !exists {
+		fmt.Printf("❌ Model not found: %s\n", modelID)
+		utils.SendErrorResponse(w, fmt.Sprintf("The model '%s' does not exist", modelID), "invalid_request_error", http.StatusNotFound, "model_not_found")
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if isExtendedView(r) {
+		json.NewEncoder(w).Encode(modelInfo)
+	} else {
+		json.NewEncoder(w).Encode(toOpenAIModel(modelInfo))
+	}
+}
+
+// catalogWithAliases returns the model catalog followed by an entry for
+// every configured alias
+func catalogWithAliases() []services.ModelInfo {
+	return append(services.GetAllModelInfo(), services.GetAliasModelInfo()...)
+}
+
+// extendedModelsResponse is the /v1/models list with full model metadata
+type extendedModelsResponse struct {
+	Object  string               `json:"object"`
+	Data    []services.ModelInfo `json:"data"`
+	FirstID string               `json:"first_id,omitempty"`
+	LastID  string               `json:"last_id,omitempty"`
+	HasMore bool                 `json:"has_more"`
+}
+
+// modelsQuery holds the filtering and pagination parameters accepted by the
+// models listing endpoints
+type modelsQuery struct {
+	filter services.ModelFilter
+	after  string
+	limit  int
+}
+
+// parseModelsQuery reads type, owner, capabilities, min_context_length, q,
+// after and limit from the query string
+func parseModelsQuery(r *http.Request) (modelsQuery, error) {
+	params := r.URL.Query()
+	query := modelsQuery{
+		filter: services.ModelFilter{
+			Type:   params.Get("type"),
+			Owner:  params.Get("owner"),
+			Search: params.Get("q"),
+		},
+		after: params.Get("after"),
+	}
+	
+	if query.filter.Type != "" && !services.IsKnownModelType(query.filter.Type) {
+		return query, fmt.Errorf("Invalid type '%s': must be one of %s", query.filter.Type, strings.Join(services.ModelTypes, ", "))
+	}
+	
+	if capabilities := params.Get("capabilities"); capabilities != "" {
+		for _, capability := range strings.Split(capabilities, ",") {
+			capability = strings.TrimSpace(capability)
+			if !services.IsKnownCapability(capability) {
+				return query, fmt.Errorf("Invalid capability '%s': must be one of tools, vision, json_mode", capability)
+			}
+			query.filter.Capabilities = append(query.filter.Capabilities, capability)
+		}
+	}
+	
+	if minContext := params.Get("min_context_length"); minContext != "" {
+		n, err := strconv.Atoi(minContext)
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("Invalid min_context_length '%s': must be a non-negative integer", minContext)
+		}
+		query.filter.MinContextLength = n
+	}
+	
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 1000 {
+			return query, fmt.Errorf("Invalid limit '%s': must be an integer between 1 and 1000", limit)
+		}
+		query.limit = n
+	}
+	
+	return query, nil
+}
+
+// apply filters the models and returns the requested page and whether more
+// models follow it
+func (q modelsQuery) apply(models []services.ModelInfo) ([]services.ModelInfo, bool) {
+	return services.PaginateModels(services.FilterModels(models, q.filter), q.after, q.limit)
+}
+
+// isExtendedView reports whether the client asked for full model metadata
+// with ?extended=true instead of the plain OpenAI model object
+func isExtendedView(r *http.Request) bool {
+	extended, _ := strconv.ParseBool(r.URL.Query().Get("extended"))
+	return extended
+}
+
+func toOpenAIModel(modelInfo services.ModelInfo) types.OpenAIModel {
+	return types.OpenAIModel{
+		ID:      modelInfo.ID,
+		Object:  "model",
+		Created: modelInfo.Created,
+		OwnedBy: modelInfo.OwnedBy,
+	}
+}
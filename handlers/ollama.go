@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+// parameterSizePattern finds the parameter count in model IDs such as
+// "Meta-Llama-3.1-70B-Instruct"
+var parameterSizePattern = regexp.MustCompile(`(?i)(?:^|[-_/])(\d+(?:\.\d+)?[bm])(?:$|[-_])`)
+
+// modelFamilyPattern finds the model family reported in /api/tags, such as
+// "llama" in "meta-llama/Meta-Llama-3.1-70B-Instruct"
+var modelFamilyPattern = regexp.MustCompile(`(?i)(?:^|[^a-z])(llama|mixtral|mistral|qwen|gemma|phi|deepseek|glm|falcon|yi|nemotron|wizardlm|olmo)`)
+
+// modelFamily returns the first family named in the model name, or "" when
+// it names none
+func modelFamily(modelID string) string {
+	match := modelFamilyPattern.FindStringSubmatch(modelID[strings.LastIndex(modelID, "/")+1:])
+	if match == nil {
+		return ""
+	}
+	return strings.ToLower(match[1])
+}
+
+// OllamaTagsHandler lists the chat models and aliases in Ollama's /api/tags
+// format
+func OllamaTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Printf("📋 Handling Ollama tags request from %s\n", r.RemoteAddr)
+
+	response := types.OllamaTagsResponse{Models: []types.OllamaModel{}}
+	for _, modelInfo := range catalogWithAliases() {
+		if modelInfo.Type != services.ModelTypeText {
+			continue
+		}
+		if modelInfo.Availability != nil && modelInfo.Availability.Status == services.AvailabilityUnavailable {
+			continue
+		}
+
+		digest := sha256.Sum256([]byte(modelInfo.ID))
+		parameterSize := ""
+		if match := parameterSizePattern.FindStringSubmatch(modelInfo.ID); match != nil {
+			parameterSize = strings.ToUpper(match[1])
+		}
+		var families []string
+		family := modelFamily(modelInfo.ID)
+		if family != "" {
+			families = []string{family}
+		}
+
+		response.Models = append(response.Models, types.OllamaModel{
+			Name:       modelInfo.ID,
+			Model:      modelInfo.ID,
+			ModifiedAt: time.Unix(modelInfo.Created, 0).UTC().Format(time.RFC3339),
+			Digest:     hex.EncodeToString(digest[:]),
+			Details: types.OllamaModelDetails{
+				Family:        family,
+				Families:      families,
+				ParameterSize: parameterSize,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// OllamaChatHandler serves Ollama's /api/chat through the chat completions
+// pipeline
+func OllamaChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Printf("💬 Ollama chat request from %s\n", r.RemoteAddr)
+
+	var req types.OllamaChatRequest
+	if err := decodeOllamaRequest(r, &req); err != nil {
+		sendOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chatReq := types.ChatCompletionRequest{Model: req.Model, Tools: req.Tools}
+	applyOllamaOptions(&chatReq, req.Options, req.Format)
+	chatReq.Messages = ollamaToChatMessages(req.Messages)
+
+	serveOllama(w, r, chatReq, req.Stream == nil || *req.Stream, false)
+}
+
+// OllamaGenerateHandler serves Ollama's /api/generate through the chat
+// completions pipeline, sending the prompt as a single user message
+func OllamaGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Printf("💬 Ollama generate request from %s\n", r.RemoteAddr)
+
+	var req types.OllamaGenerateRequest
+	if err := decodeOllamaRequest(r, &req); err != nil {
+		sendOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chatReq := types.ChatCompletionRequest{Model: req.Model}
+	applyOllamaOptions(&chatReq, req.Options, req.Format)
+	if req.System != "" {
+		chatReq.Messages = append(chatReq.Messages, types.ChatMessage{Role: "system", Content: types.TextContent(req.System)})
+	}
+	prompt := req.Prompt
+	if req.Suffix != "" {
+		prompt += "\n\nComplete the text so that it is followed by:\n" + req.Suffix
+	}
+	chatReq.Messages = append(chatReq.Messages, ollamaToChatMessage(types.OllamaMessage{
+		Role:    "user",
+		Content: prompt,
+		Images:  req.Images,
+	}, 0))
+
+	serveOllama(w, r, chatReq, req.Stream == nil || *req.Stream, true)
+}
+
+func decodeOllamaRequest(r *http.Request, v interface{}) error {
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read request body")
+	}
+	r.Body.Close()
+	if err := json.Unmarshal(bodyBytes, v); err != nil {
+		return fmt.Errorf("failed to parse request body: %v", err)
+	}
+	return nil
+}
+
+func applyOllamaOptions(chatReq *types.ChatCompletionRequest, options types.OllamaOptions, format json.RawMessage) {
+	chatReq.Temperature = options.Temperature
+	chatReq.TopP = options.TopP
+	chatReq.Seed = options.Seed
+	chatReq.PresencePenalty = options.PresencePenalty
+	chatReq.FrequencyPenalty = options.FrequencyPenalty
+	chatReq.Stop = options.Stop
+	if options.NumPredict != nil && *options.NumPredict > 0 {
+		chatReq.MaxTokens = options.NumPredict
+	}
+
+	// format is either "json" or a JSON schema
+	var formatName string
+	switch {
+	case len(format) == 0 || string(format) == "null":
+	case json.Unmarshal(format, &formatName) == nil:
+		if formatName == "json" {
+			chatReq.ResponseFormat = json.RawMessage(`{"type":"json_object"}`)
+		}
+	default:
+		chatReq.ResponseFormat, _ = json.Marshal(map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": format,
+			},
+		})
+	}
+}
+
+// ollamaToChatMessages converts a conversation. Ollama has no tool call IDs,
+// so the calls of each assistant message are given IDs unique within the
+// conversation, and the tool messages that follow take them in order, or by
+// tool name when they carry one.
+func ollamaToChatMessages(messages []types.OllamaMessage) []types.ChatMessage {
+	converted := make([]types.ChatMessage, 0, len(messages))
+	var pending []types.ToolCall
+
+	for index, message := range messages {
+		chatMessage := ollamaToChatMessage(message, index)
+		switch message.Role {
+		case "assistant":
+			pending = nil
+			if len(chatMessage.ToolCalls) > 0 {
+				json.Unmarshal(chatMessage.ToolCalls, &pending)
+			}
+		case "tool":
+			if len(pending) > 0 {
+				match := 0
+				for i, call := range pending {
+					if message.ToolName != "" && call.Function.Name == message.ToolName {
+						match = i
+						break
+					}
+				}
+				chatMessage.ToolCallID = pending[match].ID
+				pending = append(pending[:match], pending[match+1:]...)
+			}
+		default:
+			pending = nil
+		}
+		converted = append(converted, chatMessage)
+	}
+	return converted
+}
+
+// ollamaToChatMessage converts one message. index is its position in the
+// conversation, which keeps the IDs given to its tool calls unique.
+func ollamaToChatMessage(message types.OllamaMessage, index int) types.ChatMessage {
+	chatMessage := types.ChatMessage{Role: message.Role, Content: types.TextContent(message.Content)}
+
+	if len(message.Images) > 0 {
+		parts := []types.ContentPart{{Type: "text", Text: message.Content}}
+		for _, image := range message.Images {
+			parts = append(parts, types.ContentPart{
+				Type:     "image_url",
+				ImageURL: &types.ImageURL{URL: "data:" + imageMediaType(image) + ";base64," + image},
+			})
+		}
+		chatMessage.Content = types.MessageContent{Parts: parts}
+	}
+
+	if len(message.ToolCalls) > 0 {
+		calls := make([]types.ToolCall, len(message.ToolCalls))
+		for i, call := range message.ToolCalls {
+			calls[i] = types.ToolCall{
+				ID:       fmt.Sprintf("call_%d_%d", index, i),
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: call.Function.Name, Arguments: string(call.Function.Arguments)},
+			}
+		}
+		chatMessage.ToolCalls, _ = json.Marshal(calls)
+	}
+	return chatMessage
+}
+
+// imageMediaType guesses the media type of a base64 image from its first
+// bytes, which Ollama clients do not send
+func imageMediaType(data string) string {
+	switch {
+	case strings.HasPrefix(data, "iVBOR"):
+		return "image/png"
+	case strings.HasPrefix(data, "R0lGOD"):
+		return "image/gif"
+	case strings.HasPrefix(data, "UklGR"):
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// ollamaToolCalls converts OpenAI tool calls to Ollama's form, where the
+// arguments are a JSON object rather than a string
+func ollamaToolCalls(calls []types.ToolCall) []types.OllamaToolCall {
+	var converted []types.OllamaToolCall
+	for _, call := range calls {
+		var ollamaCall types.OllamaToolCall
+		ollamaCall.Function.Name = call.Function.Name
+		ollamaCall.Function.Arguments = json.RawMessage(call.Function.Arguments)
+		if !json.Valid(ollamaCall.Function.Arguments) {
+			ollamaCall.Function.Arguments = json.RawMessage("{}")
+		}
+		converted = append(converted, ollamaCall)
+	}
+	return converted
+}
+
+// serveOllama runs the request and writes the result in Ollama's format,
+// either as one object or as an NDJSON stream. generate selects the
+// /api/generate response shape.
+func serveOllama(w http.ResponseWriter, r *http.Request, chatReq types.ChatCompletionRequest, stream, generate bool) {
+	start := time.Now()
+	requestedModel := chatReq.Model
+	chatReq.Stream = stream
+	if stream {
+		chatReq.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+	}
+
+	newResponse := func(content string, toolCalls []types.ToolCall) types.OllamaResponse {
+		response := types.OllamaResponse{
+			Model:     requestedModel,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if generate {
+			response.Response = &content
+		} else {
+			response.Message = &types.OllamaMessage{Role: "assistant", Content: content, ToolCalls: ollamaToolCalls(toolCalls)}
+		}
+		return response
+	}
+	finalResponse := func(response types.OllamaResponse, finishReason string, usage *types.Usage) types.OllamaResponse {
+		response.Done = true
+		response.DoneReason = finishReason
+		if response.DoneReason == "" || response.DoneReason == "tool_calls" {
+			response.DoneReason = "stop"
+		}
+		response.TotalDuration = time.Since(start).Nanoseconds()
+		response.EvalDuration = response.TotalDuration
+		if usage != nil {
+			response.PromptEvalCount = usage.PromptTokens
+			response.EvalCount = usage.CompletionTokens
+		}
+		return response
+	}
+
+	if !stream {
+		recorder := newChunkStreamWriter()
+		runChatPipeline(recorder, r, chatReq)
+		copyPipelineHeaders(w, recorder.Header())
+		if recorder.Status() != http.StatusOK {
+			sendOllamaError(w, recorder.Status(), pipelineErrorMessage(recorder.Body.Bytes()))
+			return
+		}
+
+		var completion types.ChatCompletionResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &completion); err != nil || len(completion.Choices) == 0 {
+			sendOllamaError(w, http.StatusBadGateway, "failed to parse upstream response")
+			return
+		}
+		choice := completion.Choices[0]
+		var calls []types.ToolCall
+		if len(choice.Message.ToolCalls) > 0 {
+			json.Unmarshal(choice.Message.ToolCalls, &calls)
+		}
+		finishReason := ""
+		if choice.FinishReason != nil {
+			finishReason = *choice.FinishReason
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(finalResponse(newResponse(choice.Message.Content.String(), calls), finishReason, completion.Usage))
+		return
+	}
+
+	clearWriteDeadline(w)
+	flusher, _ := w.(http.Flusher)
+	writeLine := func(response types.OllamaResponse) {
+		json.NewEncoder(w).Encode(response)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	// Tool calls arrive in fragments but Ollama sends each call whole, so
+	// they are assembled and sent with the final line
+	var aggregator services.CompletionAggregator
+	var usage *types.Usage
+	finishReason := ""
+
+	pipeline := newChunkStreamWriter()
+	pipeline.OnStart = func(header http.Header) {
+		copyPipelineHeaders(w, header)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+	pipeline.OnChunk = func(chunk types.ChatCompletionChunk) {
+		aggregator.Add(chunk)
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+			if choice.Delta.Content != nil && *choice.Delta.Content != "" {
+				writeLine(newResponse(*choice.Delta.Content, nil))
+			}
+		}
+	}
+	pipeline.OnDone = func() {
+		var calls []types.ToolCall
+		if completion := aggregator.Completion(); len(completion.Choices) > 0 && len(completion.Choices[0].Message.ToolCalls) > 0 {
+			json.Unmarshal(completion.Choices[0].Message.ToolCalls, &calls)
+		}
+		writeLine(finalResponse(newResponse("", calls), finishReason, usage))
+	}
+
+	runChatPipeline(pipeline, r, chatReq)
+
+	if !pipeline.Streaming() {
+		copyPipelineHeaders(w, pipeline.Header())
+		sendOllamaError(w, pipeline.Status(), pipelineErrorMessage(pipeline.Body.Bytes()))
+		return
+	}
+	if !pipeline.Done() {
+		switch {
+		case pipeline.StreamError != "":
+			json.NewEncoder(w).Encode(map[string]string{"error": pipeline.StreamError})
+		case finishReason != "":
+			pipeline.finish()
+		default:
+			fmt.Fprintln(w, `{"error":"upstream stream ended unexpectedly"}`)
+		}
+	}
+}
+
+func sendOllamaError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import "testing"
+
+func TestModelFamily(t *testing.T) {
+	tests := map[string]string{
+		"meta-llama/Meta-Llama-3.1-70B-Instruct":    "llama",
+		"Qwen/Qwen2.5-72B-Instruct":                 "qwen",
+		"mistralai/Mixtral-8x7B-Instruct-v0.1":      "mixtral",
+		"deepseek-ai/DeepSeek-R1-Distill-Llama-70B": "deepseek",
+		"cognitivecomputations/dolphin-2.6-mixtral": "mixtral",
+		"microsoft/phi-4":                           "phi",
+		"Sao10K/L3.3-70B-Euryale-v2.3":              "",
+		"fast-chat":                                 "",
+	}
+	for modelID, want := range tests {
+		if got := modelFamily(modelID); got != want {
+			t.Errorf("modelFamily(%q) = %q, want %q", modelID, got, want)
+		}
+	}
+}
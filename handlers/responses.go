@@ -0,0 +1,563 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"deepinfra-wrapper/types"
+	"deepinfra-wrapper/utils"
+)
+
+// ResponsesHandler implements the OpenAI Responses API on top of the chat
+// completions pipeline. Responses are not stored, so the full conversation
+// has to be sent as input on every request.
+func ResponsesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Printf("💬 Responses request from %s\n", r.RemoteAddr)
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendErrorResponse(w, "Failed to read request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	var req types.ResponsesRequest
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		utils.SendErrorResponse(w, "Failed to parse request body: "+err.Error(), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	if req.PreviousResponseID != "" {
+		utils.SendErrorResponse(w, "previous_response_id is not supported, send the whole conversation in input", "invalid_request_error", http.StatusBadRequest, "unsupported_parameter")
+		return
+	}
+
+	chatReq, err := responsesToChatRequest(req)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error(), "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	if req.Stream {
+		streamResponses(w, r, chatReq, newResponseObject(req))
+		return
+	}
+
+	recorder := newChunkStreamWriter()
+	runChatPipeline(recorder, r, chatReq)
+	copyPipelineHeaders(w, recorder.Header())
+	if recorder.Status() != http.StatusOK {
+		forwardPipelineError(w, recorder)
+		return
+	}
+
+	var completion types.ChatCompletionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &completion); err != nil {
+		utils.SendErrorResponse(w, "Failed to parse upstream response", "api_error", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(chatToResponse(newResponseObject(req), completion))
+}
+
+// responsesToChatRequest translates a Responses request into a chat
+// completion request. Function calls become assistant tool calls and their
+// outputs become tool messages.
+func responsesToChatRequest(req types.ResponsesRequest) (types.ChatCompletionRequest, error) {
+	chatReq := types.ChatCompletionRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxOutputTokens,
+		User:        req.User,
+	}
+	if req.Stream {
+		chatReq.StreamOptions = &types.StreamOptions{IncludeUsage: true}
+	}
+
+	if req.Instructions != "" {
+		chatReq.Messages = append(chatReq.Messages, types.ChatMessage{Role: "system", Content: types.TextContent(req.Instructions)})
+	}
+
+	// Consecutive function_call items belong to one assistant turn
+	var pendingCalls []types.ToolCall
+	flushCalls := func() {
+		if len(pendingCalls) == 0 {
+			return
+		}
+		data, _ := json.Marshal(pendingCalls)
+		pendingCalls = nil
+		if last := len(chatReq.Messages) - 1; last >= 0 && chatReq.Messages[last].Role == "assistant" && len(chatReq.Messages[last].ToolCalls) == 0 {
+			chatReq.Messages[last].ToolCalls = data
+			return
+		}
+		chatReq.Messages = append(chatReq.Messages, types.ChatMessage{Role: "assistant", Content: types.NullContent(), ToolCalls: data})
+	}
+
+	for i, item := range req.Input {
+		if item.Type != "function_call" {
+			flushCalls()
+		}
+		switch item.Type {
+		case "", "message":
+			message, err := responsesMessage(item)
+			if err != nil {
+				return chatReq, fmt.Errorf("input[%d]: %v", i, err)
+			}
+			chatReq.Messages = append(chatReq.Messages, message)
+		case "function_call":
+			arguments := item.Arguments
+			if arguments == "" {
+				arguments = "{}"
+			}
+			pendingCalls = append(pendingCalls, types.ToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: item.Name, Arguments: arguments},
+			})
+		case "function_call_output":
+			chatReq.Messages = append(chatReq.Messages, types.ChatMessage{
+				Role:       "tool",
+				Content:    types.TextContent(item.Output),
+				ToolCallID: item.CallID,
+			})
+		case "reasoning":
+			// Reasoning from earlier turns is not sent upstream
+		default:
+			return chatReq, fmt.Errorf("input[%d]: unsupported item type %q", i, item.Type)
+		}
+	}
+	flushCalls()
+
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, len(req.Tools))
+		for i, tool := range req.Tools {
+			if tool.Type != "function" {
+				return chatReq, fmt.Errorf("tools[%d]: only function tools are supported, got %q", i, tool.Type)
+			}
+			function := map[string]interface{}{"name": tool.Name}
+			if tool.Description != "" {
+				function["description"] = tool.Description
+			}
+			if len(tool.Parameters) > 0 {
+				function["parameters"] = tool.Parameters
+			}
+			if tool.Strict != nil {
+				function["strict"] = *tool.Strict
+			}
+			tools[i] = map[string]interface{}{"type": "function", "function": function}
+		}
+		chatReq.Tools, _ = json.Marshal(tools)
+	}
+
+	if len(req.ToolChoice) > 0 && string(req.ToolChoice) != "null" {
+		var mode string
+		var choice struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		}
+		switch {
+		case json.Unmarshal(req.ToolChoice, &mode) == nil:
+			chatReq.ToolChoice = req.ToolChoice
+		case json.Unmarshal(req.ToolChoice, &choice) == nil && choice.Type == "function":
+			chatReq.ToolChoice, _ = json.Marshal(map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": choice.Name},
+			})
+		default:
+			return chatReq, fmt.Errorf("tool_choice: only auto, none, required and function choices are supported")
+		}
+	}
+
+	if req.Text != nil && req.Text.Format != nil {
+		format := req.Text.Format
+		switch format.Type {
+		case "", "text":
+		case "json_object":
+			chatReq.ResponseFormat = json.RawMessage(`{"type":"json_object"}`)
+		case "json_schema":
+			schema := map[string]interface{}{"name": format.Name, "schema": format.Schema}
+			if format.Strict != nil {
+				schema["strict"] = *format.Strict
+			}
+			chatReq.ResponseFormat, _ = json.Marshal(map[string]interface{}{"type": "json_schema", "json_schema": schema})
+		default:
+			return chatReq, fmt.Errorf("text.format: unsupported type %q", format.Type)
+		}
+	}
+	return chatReq, nil
+}
+
+// responsesMessage converts a message input item. Developer messages are
+// sent as system messages.
+func responsesMessage(item types.ResponsesInputItem) (types.ChatMessage, error) {
+	role := item.Role
+	switch role {
+	case "developer":
+		role = "system"
+	case "user", "system", "assistant":
+	default:
+		return types.ChatMessage{}, fmt.Errorf("unexpected role %q", item.Role)
+	}
+
+	var parts []types.ContentPart
+	hasImages := false
+	for _, part := range item.Content {
+		switch part.Type {
+		case "input_text", "output_text":
+			parts = append(parts, types.ContentPart{Type: "text", Text: part.Text})
+		case "refusal":
+			parts = append(parts, types.ContentPart{Type: "text", Text: part.Refusal})
+		case "input_image":
+			if part.ImageURL == "" {
+				return types.ChatMessage{}, fmt.Errorf("input_image: only image_url is supported")
+			}
+			parts = append(parts, types.ContentPart{Type: "image_url", ImageURL: &types.ImageURL{URL: part.ImageURL, Detail: part.Detail}})
+			hasImages = true
+		default:
+			return types.ChatMessage{}, fmt.Errorf("unsupported content type %q", part.Type)
+		}
+	}
+
+	if hasImages {
+		return types.ChatMessage{Role: role, Content: types.MessageContent{Parts: parts}}, nil
+	}
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		texts[i] = part.Text
+	}
+	return types.ChatMessage{Role: role, Content: types.TextContent(strings.Join(texts, "\n"))}, nil
+}
+
+// newResponseObject returns an in-progress response echoing the request
+// parameters, as the Responses API does
+func newResponseObject(req types.ResponsesRequest) types.ResponseObject {
+	response := types.ResponseObject{
+		Object:            "response",
+		CreatedAt:         time.Now().Unix(),
+		Status:            "in_progress",
+		Model:             req.Model,
+		Output:            []types.ResponseOutputItem{},
+		MaxOutputTokens:   req.MaxOutputTokens,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		ToolChoice:        req.ToolChoice,
+		Tools:             req.Tools,
+		ParallelToolCalls: req.ParallelToolCalls == nil || *req.ParallelToolCalls,
+		Metadata:          req.Metadata,
+		User:              req.User,
+	}
+	if req.Instructions != "" {
+		response.Instructions = &req.Instructions
+	}
+	if len(response.ToolChoice) == 0 {
+		response.ToolChoice = json.RawMessage(`"auto"`)
+	}
+	if response.Tools == nil {
+		response.Tools = []types.ResponsesTool{}
+	}
+	if response.Metadata == nil {
+		response.Metadata = map[string]string{}
+	}
+	if req.Text != nil && req.Text.Format != nil {
+		response.Text = *req.Text
+	} else {
+		response.Text.Format = &types.ResponsesTextFormat{Type: "text"}
+	}
+	return response
+}
+
+// responseIDs derives the response ID and the prefix of its item IDs from a
+// completion ID
+func responseIDs(completionID string) (string, string) {
+	suffix := strings.TrimPrefix(completionID, "chatcmpl-")
+	if suffix == "" {
+		suffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return "resp_" + suffix, suffix
+}
+
+// finishResponse sets the final status of a response from the finish reason
+// of its completion
+func finishResponse(response *types.ResponseObject, finishReason string, usage *types.Usage) {
+	response.Status = "completed"
+	switch finishReason {
+	case "length":
+		response.Status = "incomplete"
+		response.IncompleteDetails = &types.ResponsesIncomplete{Reason: "max_output_tokens"}
+	case "content_filter":
+		response.Status = "incomplete"
+		response.IncompleteDetails = &types.ResponsesIncomplete{Reason: "content_filter"}
+	}
+	if usage != nil {
+		response.Usage = &types.ResponsesUsage{
+			InputTokens:  usage.PromptTokens,
+			OutputTokens: usage.CompletionTokens,
+			TotalTokens:  usage.TotalTokens,
+		}
+	}
+}
+
+// chatToResponse converts the first choice of a completion into a response
+func chatToResponse(response types.ResponseObject, completion types.ChatCompletionResponse) types.ResponseObject {
+	var itemSuffix string
+	response.ID, itemSuffix = responseIDs(completion.ID)
+	if completion.Model != "" {
+		response.Model = completion.Model
+	}
+	if len(completion.Choices) == 0 {
+		finishResponse(&response, "", completion.Usage)
+		return response
+	}
+
+	choice := completion.Choices[0]
+	if text := choice.Message.Content.String(); text != "" {
+		response.Output = append(response.Output, types.ResponseOutputItem{
+			Type:    "message",
+			ID:      fmt.Sprintf("msg_%s_%d", itemSuffix, len(response.Output)),
+			Status:  "completed",
+			Role:    "assistant",
+			Content: &[]types.ResponsesOutputText{{Type: "output_text", Text: text, Annotations: []interface{}{}}},
+		})
+	}
+
+	var calls []types.ToolCall
+	if len(choice.Message.ToolCalls) > 0 {
+		json.Unmarshal(choice.Message.ToolCalls, &calls)
+	}
+	for i, call := range calls {
+		arguments := call.Function.Arguments
+		response.Output = append(response.Output, types.ResponseOutputItem{
+			Type:      "function_call",
+			ID:        fmt.Sprintf("fc_%s_%d", itemSuffix, i),
+			Status:    "completed",
+			CallID:    call.ID,
+			Name:      call.Function.Name,
+			Arguments: &arguments,
+		})
+	}
+
+	finishReason := ""
+	if choice.FinishReason != nil {
+		finishReason = *choice.FinishReason
+	}
+	finishResponse(&response, finishReason, completion.Usage)
+	return response
+}
+
+// streamResponses runs a streamed request and re-emits the chunks as
+// Responses API events
+func streamResponses(w http.ResponseWriter, r *http.Request, chatReq types.ChatCompletionRequest, response types.ResponseObject) {
+	clearWriteDeadline(w)
+	flusher, _ := w.(http.Flusher)
+	sequence := 0
+	send := func(event string, data map[string]interface{}) {
+		data["type"] = event
+		data["sequence_number"] = sequence
+		sequence++
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	snapshot := func() types.ResponseObject {
+		current := response
+		current.Output = append([]types.ResponseOutputItem{}, response.Output...)
+		return current
+	}
+
+	var itemSuffix string
+	started := false
+	finishReason := ""
+	finished := false
+	var usage *types.Usage
+
+	// A message item stays open until text stops for a tool call. Tool call
+	// items stay open until the stream ends, since the deltas of several
+	// calls may interleave. texts holds the text or arguments of each open
+	// item by output index, and toolItems maps the index of a streamed tool
+	// call to its output item.
+	messageItem := -1
+	texts := make(map[int]*strings.Builder)
+	toolItems := make(map[int]int)
+
+	closeItem := func(outputIndex int) {
+		text, open := texts[outputIndex]
+		if !open {
+			return
+		}
+		delete(texts, outputIndex)
+		item := &response.Output[outputIndex]
+		item.Status = "completed"
+		switch item.Type {
+		case "message":
+			part := types.ResponsesOutputText{Type: "output_text", Text: text.String(), Annotations: []interface{}{}}
+			item.Content = &[]types.ResponsesOutputText{part}
+			send("response.output_text.done", map[string]interface{}{"item_id": item.ID, "output_index": outputIndex, "content_index": 0, "text": part.Text})
+			send("response.content_part.done", map[string]interface{}{"item_id": item.ID, "output_index": outputIndex, "content_index": 0, "part": part})
+		case "function_call":
+			arguments := text.String()
+			item.Arguments = &arguments
+			send("response.function_call_arguments.done", map[string]interface{}{"item_id": item.ID, "output_index": outputIndex, "arguments": arguments})
+		}
+		send("response.output_item.done", map[string]interface{}{"output_index": outputIndex, "item": *item})
+	}
+	closeAll := func() {
+		for outputIndex := range response.Output {
+			closeItem(outputIndex)
+		}
+		messageItem = -1
+	}
+	openNewItem := func(item types.ResponseOutputItem) int {
+		response.Output = append(response.Output, item)
+		outputIndex := len(response.Output) - 1
+		texts[outputIndex] = &strings.Builder{}
+		send("response.output_item.added", map[string]interface{}{"output_index": outputIndex, "item": item})
+		return outputIndex
+	}
+
+	stream := newChunkStreamWriter()
+	stream.OnStart = func(header http.Header) {
+		copyPipelineHeaders(w, header)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+	}
+	stream.OnChunk = func(chunk types.ChatCompletionChunk) {
+		if !started {
+			started = true
+			response.ID, itemSuffix = responseIDs(chunk.ID)
+			if chunk.Model != "" {
+				response.Model = chunk.Model
+			}
+			send("response.created", map[string]interface{}{"response": snapshot()})
+			send("response.in_progress", map[string]interface{}{"response": snapshot()})
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if content := choice.Delta.Content; content != nil && *content != "" {
+				if messageItem < 0 {
+					messageItem = openNewItem(types.ResponseOutputItem{
+						Type:    "message",
+						ID:      fmt.Sprintf("msg_%s_%d", itemSuffix, len(response.Output)),
+						Status:  "in_progress",
+						Role:    "assistant",
+						Content: &[]types.ResponsesOutputText{},
+					})
+					send("response.content_part.added", map[string]interface{}{
+						"item_id":       response.Output[messageItem].ID,
+						"output_index":  messageItem,
+						"content_index": 0,
+						"part":          types.ResponsesOutputText{Type: "output_text", Text: "", Annotations: []interface{}{}},
+					})
+				}
+				texts[messageItem].WriteString(*content)
+				send("response.output_text.delta", map[string]interface{}{
+					"item_id":       response.Output[messageItem].ID,
+					"output_index":  messageItem,
+					"content_index": 0,
+					"delta":         *content,
+				})
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				outputIndex, exists := toolItems[call.Index]
+				if !exists {
+					if messageItem >= 0 {
+						closeItem(messageItem)
+						messageItem = -1
+					}
+					arguments := ""
+					outputIndex = openNewItem(types.ResponseOutputItem{
+						Type:      "function_call",
+						ID:        fmt.Sprintf("fc_%s_%d", itemSuffix, call.Index),
+						Status:    "in_progress",
+						CallID:    call.ID,
+						Name:      call.Function.Name,
+						Arguments: &arguments,
+					})
+					toolItems[call.Index] = outputIndex
+				}
+				text, open := texts[outputIndex]
+				if call.Function.Arguments != "" && open {
+					text.WriteString(call.Function.Arguments)
+					send("response.function_call_arguments.delta", map[string]interface{}{
+						"item_id":      response.Output[outputIndex].ID,
+						"output_index": outputIndex,
+						"delta":        call.Function.Arguments,
+					})
+				}
+			}
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+				finished = true
+			}
+		}
+	}
+	stream.OnDone = func() {
+		if !started {
+			return
+		}
+		closeAll()
+		finishResponse(&response, finishReason, usage)
+		event := "response.completed"
+		if response.Status == "incomplete" {
+			event = "response.incomplete"
+		}
+		send(event, map[string]interface{}{"response": snapshot()})
+	}
+
+	runChatPipeline(stream, r, chatReq)
+
+	if !stream.Streaming() {
+		copyPipelineHeaders(w, stream.Header())
+		forwardPipelineError(w, stream)
+		return
+	}
+	if !stream.Done() {
+		if finished && stream.StreamError == "" {
+			stream.finish()
+		} else {
+			message := "The upstream stream ended unexpectedly"
+			if stream.StreamError != "" {
+				message = stream.StreamError
+			}
+			closeAll()
+			response.Status = "failed"
+			response.Error = &types.ResponsesError{Code: "server_error", Message: message}
+			send("response.failed", map[string]interface{}{"response": snapshot()})
+		}
+	}
+}
+
+// forwardPipelineError passes an error response of the chat pipeline, which
+// is already in the OpenAI format, through to the client
+func forwardPipelineError(w http.ResponseWriter, recorder *chunkStreamWriter) {
+	var apiErr types.OpenAIError
+	if json.Unmarshal(recorder.Body.Bytes(), &apiErr) != nil || apiErr.Error.Message == "" {
+		utils.SendErrorResponse(w, pipelineErrorMessage(recorder.Body.Bytes()), "api_error", recorder.Status())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(recorder.Status())
+	w.Write(recorder.Body.Bytes())
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+	"deepinfra-wrapper/utils"
+)
+
+// routePlan describes where a chat request will be sent
+type routePlan struct {
+	Route            string                `json:"route,omitempty"`
+	RequestedModel   string                `json:"requested_model"`
+	RoutedModel      string                `json:"routed_model"`
+	Models           []string              `json:"models"`
+	DisableStreaming bool                  `json:"disable_streaming,omitempty"`
+	ForceStreaming   bool                  `json:"force_streaming,omitempty"`
+	FanOutN          bool                  `json:"fan_out_n,omitempty"`
+	Request          services.RouteRequest `json:"request"`
+}
+
+// planRoute applies the routing rules, then alias resolution and fallback
+// chains, returning the upstream models to try in order
+func planRoute(chatReq types.ChatCompletionRequest, r *http.Request) routePlan {
+	plan := routePlan{
+		RequestedModel: chatReq.Model,
+		RoutedModel:    chatReq.Model,
+		Request:        services.NewRouteRequest(chatReq, requestKeyName(r), r.Header),
+	}
+
+	if route, matched := services.MatchRoute(plan.Request); matched {
+		plan.Route = route.Name
+		if route.Model != "" {
+			plan.RoutedModel = route.Model
+		}
+		plan.DisableStreaming = route.DisableStreaming
+		plan.ForceStreaming = route.ForceStreaming
+		plan.FanOutN = route.FanOutN
+	}
+
+	plan.Models = services.GetFallbackChain(plan.RoutedModel)
+	return plan
+}
+
+// responseModel returns the model name to echo in responses: the name the
+// client asked for when routing or an alias replaced it, or "" to pass the
+// upstream model through
+func (p routePlan) responseModel() string {
+	if p.Models[0] == p.RequestedModel {
+		return ""
+	}
+	return p.RequestedModel
+}
+
+// RouteDryRunHandler shows which route, model and fallbacks a sample chat
+// request would use without sending anything upstream
+func RouteDryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendErrorResponse(w, "Failed to read request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	var chatReq types.ChatCompletionRequest
+	if err := json.Unmarshal(bodyBytes, &chatReq); err != nil {
+		utils.SendErrorResponse(w, "Failed to parse request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	plan := planRoute(chatReq, r)
+	fmt.Printf("🧭 Route dry run for %s: route=%q models=%v\n", chatReq.Model, plan.Route, plan.Models)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+)
+
+// errStreamStalled is returned when an upstream stream sends nothing for
+// longer than the stall timeout
+var errStreamStalled = errors.New("upstream stream stalled")
+
+// clearWriteDeadline lifts the server's write timeout for a streamed
+// response, which may run for much longer. A stalled upstream is caught by
+// the stall timeout instead. Writers that are not a client connection, such
+// as the pipeline behind a translated endpoint, are left alone.
+func clearWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// streamReader reads an upstream stream line by line on a separate
+// goroutine, so the caller can give up on a stalled upstream
+type streamReader struct {
+	lines chan string
+	err   chan error
+	done  chan struct{}
+	stall time.Duration
+}
+
+func newStreamReader(body io.Reader) *streamReader {
+	s := &streamReader{
+		lines: make(chan string),
+		err:   make(chan error, 1),
+		done:  make(chan struct{}),
+		stall: services.GetStreamStallTimeout(),
+	}
+
+	go func() {
+		defer close(s.lines)
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			select {
+			case s.lines <- scanner.Text():
+			case <-s.done:
+				return
+			}
+		}
+		s.err <- scanner.Err()
+	}()
+	return s
+}
+
+// Next returns the next line. ok is false at the end of the stream, with err
+// set when reading failed or the stream stalled.
+func (s *streamReader) Next() (line string, ok bool, err error) {
+	var stall <-chan time.Time
+	if s.stall > 0 {
+		timer := time.NewTimer(s.stall)
+		defer timer.Stop()
+		stall = timer.C
+	}
+
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			return "", false, <-s.err
+		}
+		return line, true, nil
+	case <-stall:
+		return "", false, fmt.Errorf("%w: nothing received for %s", errStreamStalled, s.stall)
+	}
+}
+
+// Close stops the reading goroutine. The caller still has to close the body
+// to unblock a pending read.
+func (s *streamReader) Close() {
+	close(s.done)
+}
+
+// writeStreamError reports a failure to a client whose stream has already
+// started, as an error event in place of the remaining chunks
+func writeStreamError(w http.ResponseWriter, message, code string) {
+	var event types.OpenAIError
+	event.Error.Message = message
+	event.Error.Type = "upstream_error"
+	event.Error.Code = code
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"deepinfra-wrapper/services"
+	"deepinfra-wrapper/types"
+	"deepinfra-wrapper/utils"
+)
+
+// tokenizeRequest accepts either chat messages or plain input text
+type tokenizeRequest struct {
+	Model    string              `json:"model"`
+	Messages []types.ChatMessage `json:"messages"`
+	Input    json.RawMessage     `json:"input"`
+	Tools    json.RawMessage     `json:"tools,omitempty"`
+}
+
+type tokenizeResponse struct {
+	Object          string `json:"object"`
+	Model           string `json:"model,omitempty"`
+	ResolvedModel   string `json:"resolved_model,omitempty"`
+	Tokens          int    `json:"tokens"`
+	MessageTokens   []int  `json:"message_tokens,omitempty"`
+	ContextLength   int    `json:"context_length,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+	Fits            *bool  `json:"fits,omitempty"`
+	Estimator       string `json:"estimator"`
+}
+
+// TokenizeHandler counts the prompt tokens of chat messages or input text
+// with the same estimator the context-window guard uses
+func TokenizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendErrorResponse(w, "Failed to read request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	var req tokenizeRequest
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		utils.SendErrorResponse(w, "Failed to parse request body", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	response := tokenizeResponse{
+		Object:    "tokenize",
+		Model:     req.Model,
+		Estimator: services.GetTokenEstimatorName(),
+	}
+
+	switch {
+	case len(req.Messages) > 0:
+		chatReq := types.ChatCompletionRequest{Messages: req.Messages, Tools: req.Tools}
+		response.Tokens = services.EstimateRequestTokens(chatReq)
+		for _, message := range req.Messages {
+			response.MessageTokens = append(response.MessageTokens, services.EstimatePromptTokens([]types.ChatMessage{message}))
+		}
+	case len(req.Input) > 0:
+		var texts []string
+		var text string
+		if err := json.Unmarshal(req.Input, &text); err == nil {
+			texts = []string{text}
+		} else if err := json.Unmarshal(req.Input, &texts); err != nil {
+			utils.SendErrorResponse(w, "input must be a string or an array of strings", "invalid_request_error", http.StatusBadRequest)
+			return
+		}
+		for _, text := range texts {
+			response.Tokens += services.CountTokens(text)
+		}
+	default:
+		utils.SendErrorResponse(w, "Either messages or input is required", "invalid_request_error", http.StatusBadRequest)
+		return
+	}
+
+	if req.Model != "" {
+		resolved, _ := services.ResolveModelAlias(req.Model)
+		if resolved != req.Model {
+			response.ResolvedModel = resolved
+		}
+		if info, exists := services.GetModelInfo(resolved); exists {
+			response.ContextLength = info.ContextLength
+			response.MaxOutputTokens = info.MaxTokens
+			if info.ContextLength > 0 {
+				fits := response.Tokens <= info.ContextLength
+				response.Fits = &fits
+			}
+		}
+	}
+
+	fmt.Printf("🔢 Counted %d tokens for %s\n", response.Tokens, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"deepinfra-wrapper/types"
+)
+
+// runChatPipeline sends a chat completion request through
+// ChatCompletionsHandler on behalf of r, keeping its context (and so its API
+// key) and headers. Other API formats translate their requests into chatReq
+// and their responses from what is written to w.
+func runChatPipeline(w http.ResponseWriter, r *http.Request, chatReq types.ChatCompletionRequest) {
+	data, err := json.Marshal(chatReq)
+	if err != nil {
+		http.Error(w, "Failed to marshal request", http.StatusInternalServerError)
+		return
+	}
+
+	req := r.Clone(r.Context())
+	req.Method = http.MethodPost
+	req.URL.Path = "/v1/chat/completions"
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	ChatCompletionsHandler(w, req)
+}
+
+// chunkStreamWriter receives the output of ChatCompletionsHandler. Streamed
+// completions are decoded chunk by chunk as they arrive and handed to
+// OnChunk, then OnDone is called at the end of the stream. An error event
+// that aborts the stream is kept in StreamError. Any other response, such
+// as an error or a complete completion, is buffered in Body.
+type chunkStreamWriter struct {
+	OnStart func(header http.Header)
+	OnChunk func(chunk types.ChatCompletionChunk)
+	OnDone  func()
+
+	StreamError string
+
+	header    http.Header
+	status    int
+	streaming bool
+	done      bool
+	pending   []byte
+	Body      bytes.Buffer
+}
+
+func newChunkStreamWriter() *chunkStreamWriter {
+	return &chunkStreamWriter{header: make(http.Header)}
+}
+
+func (c *chunkStreamWriter) Header() http.Header {
+	return c.header
+}
+
+func (c *chunkStreamWriter) WriteHeader(statusCode int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = statusCode
+	c.streaming = statusCode == http.StatusOK && strings.HasPrefix(c.header.Get("Content-Type"), "text/event-stream")
+	if c.streaming && c.OnStart != nil {
+		c.OnStart(c.header)
+	}
+}
+
+func (c *chunkStreamWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.streaming {
+		return c.Body.Write(b)
+	}
+
+	c.pending = append(c.pending, b...)
+	for {
+		end := bytes.Index(c.pending, []byte("\n\n"))
+		if end < 0 {
+			break
+		}
+		event := c.pending[:end]
+		c.pending = c.pending[end+2:]
+		c.handleEvent(event)
+	}
+	return len(b), nil
+}
+
+func (c *chunkStreamWriter) Flush() {}
+
+func (c *chunkStreamWriter) handleEvent(event []byte) {
+	for _, line := range strings.Split(string(event), "\n") {
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if payload == "[DONE]" {
+			c.finish()
+			continue
+		}
+		if strings.HasPrefix(payload, `{"error"`) {
+			c.StreamError = pipelineErrorMessage([]byte(payload))
+			continue
+		}
+		var chunk types.ChatCompletionChunk
+		if json.Unmarshal([]byte(payload), &chunk) == nil && c.OnChunk != nil {
+			c.OnChunk(chunk)
+		}
+	}
+}
+
+func (c *chunkStreamWriter) finish() {
+	if c.done {
+		return
+	}
+	c.done = true
+	if c.OnDone != nil {
+		c.OnDone()
+	}
+}
+
+// Status returns the status code the pipeline responded with
+func (c *chunkStreamWriter) Status() int {
+	if c.status == 0 {
+		return http.StatusOK
+	}
+	return c.status
+}
+
+// Streaming reports whether the pipeline responded with a stream
+func (c *chunkStreamWriter) Streaming() bool {
+	return c.streaming
+}
+
+// Done reports whether the stream ran to its end
+func (c *chunkStreamWriter) Done() bool {
+	return c.done
+}
+
+// pipelineErrorMessage extracts the message of an OpenAI-style error body
+func pipelineErrorMessage(body []byte) string {
+	var apiErr types.OpenAIError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+		return apiErr.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// copyPipelineHeaders copies the informational headers set by the chat
+// pipeline to the client response
+func copyPipelineHeaders(w http.ResponseWriter, header http.Header) {
+	for _, name := range []string{"X-Served-Model", "X-Route", "X-Cache", "X-Semantic-Cache", "X-Semantic-Similarity"} {
+		if value := header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -18,29 +19,99 @@ func main() {
 	fmt.Println("🚀 Starting DeepInfra proxy service...")
 	
 	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
+	services.InitAPIKey(apiKey)
+	
+	// The snapshot is restored first so route models can be checked
+	// against the catalog when the config is loaded
+	snapshotPath := os.Getenv("MODELS_SNAPSHOT_PATH")
+	if snapshotPath == "" {
+		snapshotPath = "data/models.json"
+	}
+	if count, err := services.InitModelSnapshot(snapshotPath); err != nil {
+		fmt.Printf("⚠️  Warning: %v\n", err)
+	} else if count > 0 {
+		fmt.Printf("📦 Loaded %d models from snapshot (stale until refreshed)\n", count)
+	}
+	
+	if err := services.LoadConfig(os.Getenv("CONFIG_FILE")); err != nil {
+		log.Fatalf("❌ Configuration error: %v", err)
+	}
+	
+	if !services.IsAuthEnabled() {
 		fmt.Println("⚠️  Warning: API_KEY environment variable not set. Authentication will be disabled.")
 	} else {
 		fmt.Println("🔐 API key authentication enabled")
 	}
 	
-	services.InitAPIKey(apiKey)
+	if err := services.InitTokenCounting(os.Getenv("TOKEN_ESTIMATOR"), os.Getenv("CONTEXT_OVERFLOW_MODE")); err != nil {
+		log.Fatalf("❌ Configuration error: %v", err)
+	}
+	
+	if err := services.InitResponseCache(
+		os.Getenv("RESPONSE_CACHE"),
+		os.Getenv("RESPONSE_CACHE_DIR"),
+		getEnvDuration("RESPONSE_CACHE_TTL"),
+		getEnvInt("RESPONSE_CACHE_MAX_ENTRIES"),
+		int64(getEnvInt("RESPONSE_CACHE_MAX_BYTES")),
+	); err != nil {
+		log.Fatalf("❌ Configuration error: %v", err)
+	}
+	
+	if err := services.InitSemanticCache(
+		os.Getenv("SEMANTIC_CACHE_MODEL"),
+		getEnvFloat("SEMANTIC_CACHE_THRESHOLD"),
+		getEnvDuration("SEMANTIC_CACHE_TTL"),
+		getEnvInt("SEMANTIC_CACHE_MAX_ENTRIES"),
+	); err != nil {
+		log.Fatalf("❌ Configuration error: %v", err)
+	}
+	
+	services.InitStreamTimeouts(
+		getEnvDuration("STREAM_HEARTBEAT_INTERVAL"),
+		getEnvDuration("STREAM_STALL_TIMEOUT"),
+	)
 	
-	initReady := make(chan bool)
-	go initializeServices(initReady)
+	services.InitFanOut(getEnvInt("FAN_OUT_MAX_N"))
 	
-	<-initReady
+	services.InitAvailabilityProbing(
+		getEnvInt("MODEL_PROBE_BUDGET"),
+		getEnvDuration("MODEL_PROBE_INTERVAL"),
+		getEnvDuration("MODEL_AVAILABILITY_TTL"),
+	)
+	
+	if err := services.InitBatchStore(
+		os.Getenv("BATCH_STORE_DIR"),
+		getEnvInt("BATCH_CONCURRENCY"),
+		handlers.ExecuteBatchRequest,
+	); err != nil {
+		log.Fatalf("❌ Batch store error: %v", err)
+	}
+	
+	go initializeServices()
 	
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/chat/completions", handlers.AuthMiddleware(handlers.ChatCompletionsHandler))
+	mux.HandleFunc("/v1/chat/ws", handlers.AuthMiddleware(handlers.ChatWebSocketHandler))
+	mux.HandleFunc("/v1/responses", handlers.AuthMiddleware(handlers.ResponsesHandler))
+	mux.HandleFunc("/v1/messages", handlers.AuthMiddleware(handlers.MessagesHandler))
+	mux.HandleFunc("/api/chat", handlers.AuthMiddleware(handlers.OllamaChatHandler))
+	mux.HandleFunc("/api/generate", handlers.AuthMiddleware(handlers.OllamaGenerateHandler))
+	mux.HandleFunc("/v1/tokenize", handlers.AuthMiddleware(handlers.TokenizeHandler))
+	mux.HandleFunc("/v1/routes/dry-run", handlers.AuthMiddleware(handlers.RouteDryRunHandler))
+	mux.HandleFunc("/v1/files", handlers.AuthMiddleware(handlers.FilesHandler))
+	mux.HandleFunc("/v1/files/", handlers.AuthMiddleware(handlers.FileHandler))
+	mux.HandleFunc("/v1/batches", handlers.AuthMiddleware(handlers.BatchesHandler))
+	mux.HandleFunc("/v1/batches/", handlers.AuthMiddleware(handlers.BatchHandler))
 	mux.HandleFunc("/v1/models", handlers.OpenAIModelsHandler)
+	mux.HandleFunc("/v1/models/", handlers.OpenAIModelHandler)
 	mux.HandleFunc("/models", handlers.ModelsHandler)
+	mux.HandleFunc("/api/tags", handlers.OllamaTagsHandler)
 	mux.HandleFunc("/docs", handlers.SwaggerHandler)
 	mux.HandleFunc("/openapi.json", handlers.OpenAPIHandler)
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("/health", handlers.LivenessHandler)
+	mux.HandleFunc("/livez", handlers.LivenessHandler)
+	mux.HandleFunc("/readyz", handlers.ReadinessHandler)
+	mux.HandleFunc("/status", handlers.StatusHandler)
 	
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -78,7 +149,7 @@ func main() {
 	fmt.Println("👋 Server shutdown complete")
 }
 
-func initializeServices(ready chan<- bool) {
+func initializeServices() {
 	fmt.Println("🔄 Initializing services...")
 	
 	fmt.Println("🔍 Searching for working proxies...")
@@ -122,8 +193,7 @@ func initializeServices(ready chan<- bool) {
 	}
 	
 	go manageProxiesAndModels()
-	
-	ready <- true
+	go services.RunAvailabilityProbes()
 	
 	fmt.Println("🎉 Service is ready to use")
 }
@@ -131,6 +201,7 @@ func initializeServices(ready chan<- bool) {
 func manageProxiesAndModels() {
 	proxyTicker := time.NewTicker(services.ProxyUpdateTime)
 	modelsTicker := time.NewTicker(services.ModelsUpdateTime)
+	probeTicker := time.NewTicker(services.GetProbeInterval())
 	
 	for {
 		select {
@@ -146,6 +217,52 @@ func manageProxiesAndModels() {
 			services.UpdateSupportedModels()
 			newCount := services.GetModelCount()
 			fmt.Printf("✅ Models refresh complete: %d → %d supported models\n", oldCount, newCount)
+		case <-probeTicker.C:
+			go services.RunAvailabilityProbes()
 		}
 	}
-}
\ No newline at end of file
+}
+
+// getEnvInt reads an integer environment variable, returning 0 when unset or invalid
+func getEnvInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("⚠️  Warning: invalid %s %q, using default\n", name, value)
+		return 0
+	}
+	return n
+}
+
+// getEnvFloat reads a numeric environment variable, returning 0 when unset
+// or invalid
+func getEnvFloat(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fmt.Printf("⚠️  Warning: invalid %s %q, using default\n", name, value)
+		return 0
+	}
+	return f
+}
+
+// getEnvDuration reads a duration environment variable such as "10m",
+// returning 0 when unset or invalid
+func getEnvDuration(name string) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("⚠️  Warning: invalid %s %q, using default\n", name, value)
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,77 @@
+package services
+
+import "sort"
+
+// ResolveModelAlias returns the upstream model an alias points to. Names that
+// are not aliases are returned unchanged with ok set to false.
+func ResolveModelAlias(name string) (string, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	if target, exists := config.Aliases[name]; exists {
+		return target, true
+	}
+	return name, false
+}
+
+// GetAliasModelInfo returns a catalog entry for every alias whose target is
+// in the catalog. Each entry carries the target's metadata under the alias ID.
+func GetAliasModelInfo() []ModelInfo {
+	configMutex.RLock()
+	aliases := make([]string, 0, len(config.Aliases))
+	for alias := range config.Aliases {
+		aliases = append(aliases, alias)
+	}
+	configMutex.RUnlock()
+	sort.Strings(aliases)
+
+	var models []ModelInfo
+	for _, alias := range aliases {
+		if info, exists := GetAliasInfo(alias); exists {
+			models = append(models, info)
+		}
+	}
+	return models
+}
+
+// GetAliasInfo returns the catalog entry for an alias
+func GetAliasInfo(alias string) (ModelInfo, bool) {
+	target, isAlias := ResolveModelAlias(alias)
+	if !isAlias {
+		return ModelInfo{}, false
+	}
+
+	info, exists := GetModelInfo(target)
+	if !exists {
+		return ModelInfo{}, false
+	}
+	info.ID = alias
+	info.AliasFor = target
+	return info, true
+}
+
+// GetFallbackChain returns the upstream models to try for a requested model
+// or alias: the model itself followed by its configured fallbacks, with
+// aliases resolved and duplicates removed. An alias without its own chain
+// uses the chain of the model it points to.
+func GetFallbackChain(model string) []string {
+	target, _ := ResolveModelAlias(model)
+
+	configMutex.RLock()
+	fallbacks, exists := config.Fallbacks[model]
+	if !exists {
+		fallbacks = config.Fallbacks[target]
+	}
+	configMutex.RUnlock()
+
+	chain := []string{target}
+	seen := map[string]bool{target: true}
+	for _, fallback := range fallbacks {
+		resolved, _ := ResolveModelAlias(fallback)
+		if !seen[resolved] {
+			seen[resolved] = true
+			chain = append(chain, resolved)
+		}
+	}
+	return chain
+}
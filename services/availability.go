@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Availability states tracked for each model
+const (
+	AvailabilityUnknown     = "unknown"
+	AvailabilityAvailable   = "available"
+	AvailabilityUnavailable = "unavailable"
+)
+
+// Breaker states derived from a model's availability: closed while it takes
+// traffic, open while it is skipped as unavailable, and half-open once an
+// unavailable model is due to be probed again
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// maxConsecutiveFailures is how many upstream errors in a row mark a model
+// unavailable when the errors do not identify the model as the cause
+const maxConsecutiveFailures = 3
+
+// ModelAvailability is what is known about a model from real traffic and probes
+type ModelAvailability struct {
+	Status              string `json:"status"`
+	LastSeen            int64  `json:"last_seen,omitempty"`
+	LastSuccess         int64  `json:"last_success,omitempty"`
+	LastFailure         int64  `json:"last_failure,omitempty"`
+	LastProbe           int64  `json:"last_probe,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures,omitempty"`
+}
+
+var (
+	modelAvailability = make(map[string]ModelAvailability)
+	availabilityMutex sync.RWMutex
+	probing           atomic.Bool
+
+	probeBudget     = 20
+	probeInterval   = 10 * time.Minute
+	availabilityTTL = 60 * time.Minute
+	probeJitter     = 5 * time.Second
+)
+
+// InitAvailabilityProbing configures how many models a probe cycle may test,
+// how often cycles run and how long an observation stays fresh. Non-positive
+// values keep the defaults.
+func InitAvailabilityProbing(budget int, interval, ttl time.Duration) {
+	if budget > 0 {
+		probeBudget = budget
+	}
+	if interval > 0 {
+		probeInterval = interval
+	}
+	if ttl > 0 {
+		availabilityTTL = ttl
+	}
+}
+
+// GetProbeInterval returns how often availability probe cycles should run
+func GetProbeInterval() time.Duration {
+	return probeInterval
+}
+
+// GetModelAvailability returns the availability state of a model
+func GetModelAvailability(model string) ModelAvailability {
+	availabilityMutex.RLock()
+	defer availabilityMutex.RUnlock()
+
+	if state, exists := modelAvailability[model]; exists {
+		return state
+	}
+	return ModelAvailability{Status: AvailabilityUnknown}
+}
+
+// GetTrackedAvailability returns the availability of every model seen by
+// traffic or probes
+func GetTrackedAvailability() map[string]ModelAvailability {
+	return copyAvailability()
+}
+
+// BreakerState returns the breaker state of a model with the given
+// availability
+func BreakerState(state ModelAvailability) string {
+	if state.Status != AvailabilityUnavailable {
+		return BreakerClosed
+	}
+	lastChecked := state.LastSeen
+	if state.LastProbe > lastChecked {
+		lastChecked = state.LastProbe
+	}
+	if lastChecked < time.Now().Add(-availabilityTTL).Unix() {
+		return BreakerHalfOpen
+	}
+	return BreakerOpen
+}
+
+// IsModelUnavailable reports whether the model is known to be unavailable
+func IsModelUnavailable(model string) bool {
+	return GetModelAvailability(model).Status == AvailabilityUnavailable
+}
+
+// RecordModelSuccess marks a model available after a successful completion
+func RecordModelSuccess(model string) {
+	now := time.Now().Unix()
+
+	availabilityMutex.Lock()
+	defer availabilityMutex.Unlock()
+
+	state := modelAvailability[model]
+	if state.Status != AvailabilityAvailable {
+		fmt.Printf("✅ Model available: %s\n", model)
+	}
+	state.Status = AvailabilityAvailable
+	state.LastSeen = now
+	state.LastSuccess = now
+	state.ConsecutiveFailures = 0
+	modelAvailability[model] = state
+}
+
+// RecordModelFailure records an upstream error for a model. Definitive
+// failures (the model is missing or not accessible) mark it unavailable
+// immediately, others only after several consecutive errors.
+func RecordModelFailure(model string, definitive bool) {
+	now := time.Now().Unix()
+
+	availabilityMutex.Lock()
+	defer availabilityMutex.Unlock()
+
+	state := modelAvailability[model]
+	state.LastSeen = now
+	state.LastFailure = now
+	state.ConsecutiveFailures++
+	if definitive || state.ConsecutiveFailures >= maxConsecutiveFailures {
+		if state.Status != AvailabilityUnavailable {
+			fmt.Printf("🚫 Model unavailable: %s\n", model)
+		}
+		state.Status = AvailabilityUnavailable
+	} else if state.Status == "" {
+		state.Status = AvailabilityUnknown
+	}
+	modelAvailability[model] = state
+}
+
+// GetAvailabilityCounts returns how many catalog models are in each state
+func GetAvailabilityCounts() map[string]int {
+	models := GetAllModels()
+
+	availabilityMutex.RLock()
+	defer availabilityMutex.RUnlock()
+
+	counts := map[string]int{
+		AvailabilityAvailable:   0,
+		AvailabilityUnavailable: 0,
+		AvailabilityUnknown:     0,
+	}
+	for _, model := range models {
+		status := AvailabilityUnknown
+		if state, exists := modelAvailability[model]; exists && state.Status != "" {
+			status = state.Status
+		}
+		counts[status]++
+	}
+	return counts
+}
+
+// RunAvailabilityProbes actively probes the catalog models that have not been
+// seen within the availability TTL, oldest first, up to the probe budget.
+// Probes are spread out with random jitter so they never arrive upstream as a
+// burst. Only one cycle runs at a time.
+func RunAvailabilityProbes() {
+	if !probing.CompareAndSwap(false, true) {
+		return
+	}
+	defer probing.Store(false)
+
+	candidates := staleModels()
+	if len(candidates) == 0 {
+		return
+	}
+	if len(candidates) > probeBudget {
+		candidates = candidates[:probeBudget]
+	}
+
+	fmt.Printf("🔍 Probing %d models not seen recently...\n", len(candidates))
+
+	for _, model := range candidates {
+		time.Sleep(time.Duration(rand.Int63n(int64(probeJitter))))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+		result := probeModel(ctx, model)
+		cancel()
+
+		// A probe that never reached the model, during a proxy outage or
+		// upstream trouble, leaves it to be probed again next cycle
+		if result == probeInconclusive {
+			fmt.Printf("⚠️ Probe of %s was inconclusive\n", model)
+			continue
+		}
+
+		availabilityMutex.Lock()
+		state := modelAvailability[model]
+		state.LastProbe = time.Now().Unix()
+		modelAvailability[model] = state
+		availabilityMutex.Unlock()
+
+		if result == probeAccessible {
+			RecordModelSuccess(model)
+		} else {
+			RecordModelFailure(model, true)
+		}
+	}
+
+	fmt.Println("✅ Availability probe cycle complete")
+}
+
+// staleModels returns catalog models whose last observation is older than the
+// availability TTL, least recently seen first
+func staleModels() []string {
+	// Probes are chat completions, which only text models can answer
+	var models []string
+	for _, model := range GetAllModels() {
+		if SupportsChat(model) {
+			models = append(models, model)
+		}
+	}
+	cutoff := time.Now().Add(-availabilityTTL).Unix()
+
+	availabilityMutex.RLock()
+	defer availabilityMutex.RUnlock()
+
+	var stale []string
+	for _, model := range models {
+		state := modelAvailability[model]
+		lastChecked := state.LastSeen
+		if state.LastProbe > lastChecked {
+			lastChecked = state.LastProbe
+		}
+		if lastChecked < cutoff {
+			stale = append(stale, model)
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return modelAvailability[stale[i]].LastSeen < modelAvailability[stale[j]].LastSeen
+	})
+	return stale
+}
+
+func copyAvailability() map[string]ModelAvailability {
+	availabilityMutex.RLock()
+	defer availabilityMutex.RUnlock()
+
+	states := make(map[string]ModelAvailability, len(modelAvailability))
+	for model, state := range modelAvailability {
+		states[model] = state
+	}
+	return states
+}
+
+func restoreAvailability(states map[string]ModelAvailability) {
+	availabilityMutex.Lock()
+	defer availabilityMutex.Unlock()
+
+	for model, state := range states {
+		modelAvailability[model] = state
+	}
+}
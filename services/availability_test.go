@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerState(t *testing.T) {
+	now := time.Now()
+	stale := now.Add(-2 * availabilityTTL).Unix()
+	tests := []struct {
+		name  string
+		state ModelAvailability
+		want  string
+	}{
+		{name: "unknown", state: ModelAvailability{Status: AvailabilityUnknown, ConsecutiveFailures: 2, LastFailure: now.Unix()}, want: BreakerClosed},
+		{name: "available", state: ModelAvailability{Status: AvailabilityAvailable, LastSeen: now.Unix()}, want: BreakerClosed},
+		{name: "recently unavailable", state: ModelAvailability{Status: AvailabilityUnavailable, LastSeen: now.Unix()}, want: BreakerOpen},
+		{name: "recently probed", state: ModelAvailability{Status: AvailabilityUnavailable, LastSeen: stale, LastProbe: now.Unix()}, want: BreakerOpen},
+		{name: "due for a probe", state: ModelAvailability{Status: AvailabilityUnavailable, LastSeen: stale, LastProbe: stale}, want: BreakerHalfOpen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BreakerState(test.state); got != test.want {
+				t.Errorf("BreakerState() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,826 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batch statuses, as in the OpenAI Batch API
+const (
+	BatchValidating = "validating"
+	BatchFailed     = "failed"
+	BatchInProgress = "in_progress"
+	BatchFinalizing = "finalizing"
+	BatchCompleted  = "completed"
+	BatchExpired    = "expired"
+	BatchCancelling = "cancelling"
+	BatchCancelled  = "cancelled"
+)
+
+// File purposes
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// BatchEndpoint is the only endpoint batches can target
+const BatchEndpoint = "/v1/chat/completions"
+
+// BatchCompletionWindow is the only supported completion window
+const BatchCompletionWindow = "24h"
+
+// ErrNotFound is returned for files and batches that do not exist or belong
+// to another API key
+var ErrNotFound = errors.New("not found")
+
+// FileObject describes an uploaded or generated file
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// Batch is a job running the requests of an input file in the background
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors,omitempty"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     string             `json:"output_file_id,omitempty"`
+	ErrorFileID      string             `json:"error_file_id,omitempty"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     int64              `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64              `json:"expires_at,omitempty"`
+	FinalizingAt     int64              `json:"finalizing_at,omitempty"`
+	CompletedAt      int64              `json:"completed_at,omitempty"`
+	FailedAt         int64              `json:"failed_at,omitempty"`
+	ExpiredAt        int64              `json:"expired_at,omitempty"`
+	CancellingAt     int64              `json:"cancelling_at,omitempty"`
+	CancelledAt      int64              `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+}
+
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchExecutor runs one request body through the chat pipeline on behalf
+// of an API key and returns the HTTP status and response body. A status of
+// 0 means the request got no response, and the body then holds the reason.
+type BatchExecutor func(ctx context.Context, keyName string, body []byte) (int, []byte)
+
+// fileRecord and batchRecord are what the store persists: the public
+// objects plus the API key that owns them
+type fileRecord struct {
+	FileObject
+	Owner string `json:"owner,omitempty"`
+}
+
+type batchRecord struct {
+	Batch
+	Owner string `json:"owner,omitempty"`
+	// Results are written to these files while the batch runs and the
+	// files are only published when it ends
+	PendingOutputID string `json:"pending_output_file_id"`
+	PendingErrorID  string `json:"pending_error_file_id"`
+}
+
+// batchRequestLine is one line of a batch input file
+type batchRequestLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// batchResultLine is one line of a batch output or error file
+type batchResultLine struct {
+	ID       string         `json:"id"`
+	CustomID string         `json:"custom_id"`
+	Response *batchResponse `json:"response"`
+	Error    *BatchError    `json:"error"`
+}
+
+// Succeeded reports whether the request got a 200 response
+func (l batchResultLine) Succeeded() bool {
+	return l.Error == nil && l.Response != nil && l.Response.StatusCode == http.StatusOK
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+var (
+	batchDir       string
+	files          = make(map[string]*fileRecord)
+	batches        = make(map[string]*batchRecord)
+	batchMutex     sync.Mutex
+	batchSemaphore chan struct{}
+	batchExecutor  BatchExecutor
+)
+
+// InitBatchStore loads files and batches persisted under dir and resumes
+// the batches that were running when the process stopped. At most
+// concurrency batch requests are in flight at a time.
+func InitBatchStore(dir string, concurrency int, executor BatchExecutor) error {
+	if dir == "" {
+		dir = "data"
+	}
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	for _, sub := range []string{"files", "batches"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return fmt.Errorf("failed to create batch store: %v", err)
+		}
+	}
+
+	batchDir = dir
+	batchSemaphore = make(chan struct{}, concurrency)
+	batchExecutor = executor
+
+	fileMetas, _ := filepath.Glob(filepath.Join(dir, "files", "*.json"))
+	for _, path := range fileMetas {
+		var record fileRecord
+		if err := readJSONFile(path, &record); err != nil {
+			fmt.Printf("⚠️ Skipping unreadable file metadata %s: %v\n", path, err)
+			continue
+		}
+		files[record.ID] = &record
+	}
+
+	var resume []string
+	batchMetas, _ := filepath.Glob(filepath.Join(dir, "batches", "*.json"))
+	for _, path := range batchMetas {
+		var record batchRecord
+		if err := readJSONFile(path, &record); err != nil {
+			fmt.Printf("⚠️ Skipping unreadable batch %s: %v\n", path, err)
+			continue
+		}
+		batches[record.ID] = &record
+		if !isBatchTerminal(record.Status) {
+			resume = append(resume, record.ID)
+		}
+	}
+
+	fmt.Printf("📦 Batch store loaded %d files and %d batches\n", len(files), len(batches))
+	for _, id := range resume {
+		fmt.Printf("🔁 Resuming batch %s\n", id)
+		go runBatch(id)
+	}
+	return nil
+}
+
+// CreateFile stores an uploaded file
+func CreateFile(owner, filename, purpose string, content io.Reader) (FileObject, error) {
+	record := &fileRecord{
+		FileObject: FileObject{
+			ID:        newObjectID("file-"),
+			Object:    "file",
+			CreatedAt: time.Now().Unix(),
+			Filename:  filename,
+			Purpose:   purpose,
+		},
+		Owner: owner,
+	}
+
+	path := fileContentPath(record.ID)
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return FileObject{}, fmt.Errorf("failed to store file: %v", err)
+	}
+	n, err := io.Copy(out, content)
+	out.Close()
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return FileObject{}, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return FileObject{}, fmt.Errorf("failed to store file: %v", err)
+	}
+	record.Bytes = n
+
+	if err := registerFile(record); err != nil {
+		os.Remove(path)
+		return FileObject{}, err
+	}
+	fmt.Printf("📁 Stored file %s (%s, %d bytes)\n", record.ID, filename, n)
+	return record.FileObject, nil
+}
+
+// ListFiles returns the files of an API key, newest first, optionally
+// filtered by purpose
+func ListFiles(owner, purpose string) []FileObject {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	var list []FileObject
+	for _, record := range files {
+		if record.Owner == owner && (purpose == "" || record.Purpose == purpose) {
+			list = append(list, record.FileObject)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// GetFile returns a file of an API key and the path of its content
+func GetFile(owner, id string) (FileObject, string, error) {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	record, exists := files[id]
+	if !exists || record.Owner != owner {
+		return FileObject{}, "", ErrNotFound
+	}
+	return record.FileObject, fileContentPath(id), nil
+}
+
+// DeleteFile removes a file and its content
+func DeleteFile(owner, id string) error {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	record, exists := files[id]
+	if !exists || record.Owner != owner {
+		return ErrNotFound
+	}
+	delete(files, id)
+	os.Remove(fileContentPath(id))
+	os.Remove(fileMetaPath(id))
+	return nil
+}
+
+// CreateBatch validates the request and starts the batch in the background
+func CreateBatch(owner, inputFileID, endpoint, completionWindow string, metadata map[string]string) (Batch, error) {
+	if endpoint != BatchEndpoint {
+		return Batch{}, fmt.Errorf("unsupported endpoint %q: only %s is supported", endpoint, BatchEndpoint)
+	}
+	if completionWindow != BatchCompletionWindow {
+		return Batch{}, fmt.Errorf("unsupported completion_window %q: only %s is supported", completionWindow, BatchCompletionWindow)
+	}
+
+	input, _, err := GetFile(owner, inputFileID)
+	if err != nil {
+		return Batch{}, fmt.Errorf("input file %s not found", inputFileID)
+	}
+	if input.Purpose != FilePurposeBatch {
+		return Batch{}, fmt.Errorf("input file %s must have purpose %q", inputFileID, FilePurposeBatch)
+	}
+
+	now := time.Now()
+	record := &batchRecord{
+		Batch: Batch{
+			ID:               newObjectID("batch_"),
+			Object:           "batch",
+			Endpoint:         endpoint,
+			InputFileID:      inputFileID,
+			CompletionWindow: completionWindow,
+			Status:           BatchValidating,
+			CreatedAt:        now.Unix(),
+			ExpiresAt:        now.Add(24 * time.Hour).Unix(),
+			Metadata:         metadata,
+		},
+		Owner:           owner,
+		PendingOutputID: newObjectID("file-"),
+		PendingErrorID:  newObjectID("file-"),
+	}
+
+	batchMutex.Lock()
+	batches[record.ID] = record
+	err = saveBatchLocked(record)
+	batch := record.Batch
+	batchMutex.Unlock()
+	if err != nil {
+		return Batch{}, err
+	}
+
+	fmt.Printf("📦 Created batch %s from %s\n", batch.ID, inputFileID)
+	go runBatch(batch.ID)
+	return batch, nil
+}
+
+// GetBatch returns a batch of an API key
+func GetBatch(owner, id string) (Batch, error) {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	record, exists := batches[id]
+	if !exists || record.Owner != owner {
+		return Batch{}, ErrNotFound
+	}
+	return record.Batch, nil
+}
+
+// ListBatches returns the batches of an API key, newest first
+func ListBatches(owner string) []Batch {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	var list []Batch
+	for _, record := range batches {
+		if record.Owner == owner {
+			list = append(list, record.Batch)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// CancelBatch stops a batch from starting new requests. Requests already in
+// flight finish and their results are kept.
+func CancelBatch(owner, id string) (Batch, error) {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	record, exists := batches[id]
+	if !exists || record.Owner != owner {
+		return Batch{}, ErrNotFound
+	}
+	switch record.Status {
+	case BatchValidating, BatchInProgress:
+	default:
+		return Batch{}, fmt.Errorf("cannot cancel a batch with status %s", record.Status)
+	}
+
+	record.Status = BatchCancelling
+	record.CancellingAt = time.Now().Unix()
+	saveBatchLocked(record)
+	fmt.Printf("🛑 Cancelling batch %s\n", id)
+	return record.Batch, nil
+}
+
+func isBatchTerminal(status string) bool {
+	switch status {
+	case BatchCompleted, BatchFailed, BatchExpired, BatchCancelled:
+		return true
+	}
+	return false
+}
+
+// runBatch validates the input of a batch if needed, then runs every
+// request that has no result yet and publishes the output files
+func runBatch(id string) {
+	batchMutex.Lock()
+	record := batches[id]
+	status := record.Status
+	started := record.InProgressAt != 0
+	owner := record.Owner
+	inputPath := fileContentPath(record.InputFileID)
+	outputPath := fileContentPath(record.PendingOutputID)
+	errorPath := fileContentPath(record.PendingErrorID)
+	batchMutex.Unlock()
+
+	if status == BatchValidating || (status == BatchCancelling && !started) {
+		total, lineErrors := validateBatchInput(inputPath)
+		batchMutex.Lock()
+		now := time.Now().Unix()
+		switch {
+		case len(lineErrors) > 0:
+			record.Status = BatchFailed
+			record.FailedAt = now
+			record.Errors = &BatchErrors{Object: "list", Data: lineErrors}
+		case record.Status == BatchCancelling:
+			record.Status = BatchCancelled
+			record.CancelledAt = now
+		default:
+			record.Status = BatchInProgress
+			record.InProgressAt = now
+			record.RequestCounts.Total = total
+		}
+		saveBatchLocked(record)
+		status = record.Status
+		batchMutex.Unlock()
+
+		if status != BatchInProgress {
+			fmt.Printf("❌ Batch %s %s before it started\n", id, status)
+			return
+		}
+	}
+
+	var failure error
+	if status == BatchInProgress || status == BatchCancelling {
+		failure = processBatchRequests(record, owner, inputPath, outputPath, errorPath)
+	}
+	finalizeBatch(record, outputPath, errorPath, failure)
+}
+
+// validateBatchInput checks every line of an input file and returns the
+// number of requests
+func validateBatchInput(path string) (int, []BatchError) {
+	var lineErrors []BatchError
+	seen := make(map[string]bool)
+	total := 0
+
+	err := forEachLine(path, func(lineNumber int, line []byte) bool {
+		var request batchRequestLine
+		switch {
+		case json.Unmarshal(line, &request) != nil:
+			lineErrors = append(lineErrors, BatchError{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON.", Line: lineNumber})
+		case request.CustomID == "":
+			lineErrors = append(lineErrors, BatchError{Code: "missing_required_parameter", Message: "custom_id is required.", Line: lineNumber})
+		case seen[request.CustomID]:
+			lineErrors = append(lineErrors, BatchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("The custom_id %q is used more than once.", request.CustomID), Line: lineNumber})
+		case request.Method != http.MethodPost:
+			lineErrors = append(lineErrors, BatchError{Code: "invalid_method", Message: "Only POST requests are supported.", Line: lineNumber})
+		case request.URL != BatchEndpoint:
+			lineErrors = append(lineErrors, BatchError{Code: "invalid_url", Message: fmt.Sprintf("The url must be %s.", BatchEndpoint), Line: lineNumber})
+		case len(request.Body) == 0 || request.Body[0] != '{':
+			lineErrors = append(lineErrors, BatchError{Code: "invalid_request", Message: "The body must be a JSON object.", Line: lineNumber})
+		}
+		seen[request.CustomID] = true
+		total++
+		// Reporting the first errors is enough to fix the file
+		return len(lineErrors) < 100
+	})
+	if err != nil {
+		lineErrors = append(lineErrors, BatchError{Code: "file_unreadable", Message: err.Error()})
+	}
+	if total == 0 && len(lineErrors) == 0 {
+		lineErrors = append(lineErrors, BatchError{Code: "empty_file", Message: "The input file contains no requests."})
+	}
+	return total, lineErrors
+}
+
+// processBatchRequests runs the requests of the input file that are not yet
+// in the output or error file, stopping early when the batch is cancelled
+// or expires. As in the OpenAI batch format, every response goes to the
+// output file, with its status code, and the error file only holds the
+// requests that got no response. An error means the batch could not run
+// all of its requests.
+func processBatchRequests(record *batchRecord, owner, inputPath, outputPath, errorPath string) error {
+	done := make(map[string]bool)
+	completed, failed := recoverResultFile(outputPath, done)
+	_, unanswered := recoverResultFile(errorPath, done)
+
+	batchMutex.Lock()
+	record.RequestCounts.Completed = completed
+	record.RequestCounts.Failed = failed + unanswered
+	batchMutex.Unlock()
+
+	outputFile, err := os.OpenFile(outputPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot write the output file: %v", err)
+	}
+	defer outputFile.Close()
+	errorFile, err := os.OpenFile(errorPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot write the error file: %v", err)
+	}
+	defer errorFile.Close()
+
+	var wg sync.WaitGroup
+	var writeMutex sync.Mutex
+	results := 0
+
+	err = forEachLine(inputPath, func(_ int, line []byte) bool {
+		var request batchRequestLine
+		if json.Unmarshal(line, &request) != nil || done[request.CustomID] {
+			return true
+		}
+
+		batchMutex.Lock()
+		stopping := record.Status == BatchCancelling || time.Now().Unix() >= record.ExpiresAt
+		batchMutex.Unlock()
+		if stopping {
+			return false
+		}
+
+		batchSemaphore <- struct{}{}
+		wg.Add(1)
+		go func(request batchRequestLine) {
+			defer wg.Done()
+			defer func() { <-batchSemaphore }()
+
+			result := executeBatchRequest(owner, request)
+			data, _ := json.Marshal(result)
+			data = append(data, '\n')
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+
+			succeeded := result.Succeeded()
+			target := outputFile
+			if result.Response == nil {
+				target = errorFile
+			}
+			if _, err := target.Write(data); err != nil {
+				fmt.Printf("❌ Batch %s failed to write a result: %v\n", record.ID, err)
+				return
+			}
+
+			batchMutex.Lock()
+			if succeeded {
+				record.RequestCounts.Completed++
+			} else {
+				record.RequestCounts.Failed++
+			}
+			results++
+			// Counts are recovered from the result files on restart, so they
+			// only need to be persisted now and then
+			if results%25 == 0 {
+				saveBatchLocked(record)
+			}
+			batchMutex.Unlock()
+		}(request)
+		return true
+	})
+	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to read the input file: %v", err)
+	}
+	return nil
+}
+
+// executeBatchRequest runs one request, retrying when the service is busy
+// or the upstream fails
+func executeBatchRequest(owner string, request batchRequestLine) batchResultLine {
+	body := request.Body
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) == nil {
+		// Batch results are always complete responses
+		fields["stream"] = json.RawMessage("false")
+		body, _ = json.Marshal(fields)
+	}
+
+	var status int
+	var responseBody []byte
+	for attempt := 0; attempt < MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * time.Second)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		status, responseBody = batchExecutor(ctx, owner, body)
+		cancel()
+		if status != 0 && status != http.StatusTooManyRequests && status < http.StatusInternalServerError {
+			break
+		}
+	}
+
+	if status == 0 {
+		return batchResultLine{
+			ID:       newObjectID("batch_req_"),
+			CustomID: request.CustomID,
+			Error:    &BatchError{Code: "request_failed", Message: strings.TrimSpace(string(responseBody))},
+		}
+	}
+	if !json.Valid(responseBody) {
+		responseBody, _ = json.Marshal(strings.TrimSpace(string(responseBody)))
+	}
+	return batchResultLine{
+		ID:       newObjectID("batch_req_"),
+		CustomID: request.CustomID,
+		Response: &batchResponse{
+			StatusCode: status,
+			RequestID:  newObjectID("req_"),
+			Body:       responseBody,
+		},
+	}
+}
+
+// finalizeBatch publishes the output and error files and records how the
+// batch ended. A failure ends the batch as failed, keeping the results it
+// has.
+func finalizeBatch(record *batchRecord, outputPath, errorPath string, failure error) {
+	batchMutex.Lock()
+	if isBatchTerminal(record.Status) {
+		batchMutex.Unlock()
+		return
+	}
+	if failure != nil {
+		fmt.Printf("❌ Batch %s failed: %v\n", record.ID, failure)
+		record.Errors = &BatchErrors{Object: "list", Data: []BatchError{{Code: "batch_failed", Message: failure.Error()}}}
+	}
+	endStatus := batchEndStatus(record, time.Now())
+	record.Status = BatchFinalizing
+	record.FinalizingAt = time.Now().Unix()
+	saveBatchLocked(record)
+	batchMutex.Unlock()
+
+	outputID := publishResultFile(record, record.PendingOutputID, outputPath, "_output.jsonl")
+	errorID := publishResultFile(record, record.PendingErrorID, errorPath, "_error.jsonl")
+
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	now := time.Now().Unix()
+	record.OutputFileID = outputID
+	record.ErrorFileID = errorID
+	record.Status = endStatus
+	switch endStatus {
+	case BatchFailed:
+		record.FailedAt = now
+	case BatchCancelled:
+		record.CancelledAt = now
+	case BatchExpired:
+		record.ExpiredAt = now
+	default:
+		record.CompletedAt = now
+	}
+	saveBatchLocked(record)
+
+	fmt.Printf("📦 Batch %s %s: %d completed, %d failed of %d\n", record.ID, endStatus,
+		record.RequestCounts.Completed, record.RequestCounts.Failed, record.RequestCounts.Total)
+}
+
+// batchEndStatus decides how a batch ends from its persisted state, so a
+// batch resumed while finalizing ends the way it would have before the
+// restart
+func batchEndStatus(record *batchRecord, now time.Time) string {
+	switch {
+	case record.Errors != nil:
+		return BatchFailed
+	case record.CancellingAt != 0:
+		return BatchCancelled
+	case now.Unix() >= record.ExpiresAt && record.RequestCounts.Completed+record.RequestCounts.Failed < record.RequestCounts.Total:
+		return BatchExpired
+	}
+	return BatchCompleted
+}
+
+// publishResultFile registers a non-empty result file and returns its ID
+func publishResultFile(record *batchRecord, id, path, suffix string) string {
+	stat, err := os.Stat(path)
+	if err != nil || stat.Size() == 0 {
+		os.Remove(path)
+		return ""
+	}
+
+	file := &fileRecord{
+		FileObject: FileObject{
+			ID:        id,
+			Object:    "file",
+			Bytes:     stat.Size(),
+			CreatedAt: time.Now().Unix(),
+			Filename:  record.ID + suffix,
+			Purpose:   FilePurposeBatchOutput,
+		},
+		Owner: record.Owner,
+	}
+	if err := registerFile(file); err != nil {
+		fmt.Printf("❌ Failed to publish %s: %v\n", file.Filename, err)
+		return ""
+	}
+	return id
+}
+
+// recoverResultFile collects the custom IDs already in a result file and
+// returns how many of them succeeded and failed. A line cut short by a
+// crash is truncated so its request runs again.
+func recoverResultFile(path string, done map[string]bool) (succeeded, failed int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+
+	valid := 0
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			break
+		}
+		var result batchResultLine
+		if json.Unmarshal(data[offset:offset+end], &result) != nil {
+			break
+		}
+		done[result.CustomID] = true
+		if result.Succeeded() {
+			succeeded++
+		} else {
+			failed++
+		}
+		offset += end + 1
+		valid = offset
+	}
+	if valid < len(data) {
+		os.Truncate(path, int64(valid))
+	}
+	return succeeded, failed
+}
+
+// forEachLine calls fn with every non-empty line of a file and its 1-based
+// line number until fn returns false. Lines may be of any length.
+func forEachLine(path string, fn func(lineNumber int, line []byte) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if !fn(lineNumber, trimmed) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func registerFile(record *fileRecord) error {
+	batchMutex.Lock()
+	defer batchMutex.Unlock()
+
+	if err := writeJSONFile(fileMetaPath(record.ID), record); err != nil {
+		return fmt.Errorf("failed to store file metadata: %v", err)
+	}
+	files[record.ID] = record
+	return nil
+}
+
+// saveBatchLocked persists a batch; batchMutex must be held
+func saveBatchLocked(record *batchRecord) error {
+	err := writeJSONFile(filepath.Join(batchDir, "batches", record.ID+".json"), record)
+	if err != nil {
+		fmt.Printf("⚠️ Failed to persist batch %s: %v\n", record.ID, err)
+	}
+	return err
+}
+
+func fileContentPath(id string) string {
+	return filepath.Join(batchDir, "files", id+".jsonl")
+}
+
+func fileMetaPath(id string) string {
+	return filepath.Join(batchDir, "files", id+".json")
+}
+
+func newObjectID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// writeJSONFile writes v atomically so a crash never leaves a torn file
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.jsonl")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateBatchInput(t *testing.T) {
+	line := func(customID, method, url, body string) string {
+		return `{"custom_id":"` + customID + `","method":"` + method + `","url":"` + url + `","body":` + body + "}\n"
+	}
+	valid := func(customID string) string {
+		return line(customID, http.MethodPost, BatchEndpoint, `{"model":"m"}`)
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		wantTotal int
+		wantCodes []string
+		wantLines []int
+	}{
+		{
+			name:      "valid file",
+			content:   valid("a") + "\n" + valid("b"),
+			wantTotal: 2,
+		},
+		{
+			name:      "empty file",
+			content:   "\n\n",
+			wantCodes: []string{"empty_file"},
+			wantLines: []int{0},
+		},
+		{
+			name: "every kind of malformed line",
+			content: valid("a") +
+				"not json\n" +
+				line("", http.MethodPost, BatchEndpoint, `{}`) +
+				valid("a") +
+				line("b", http.MethodGet, BatchEndpoint, `{}`) +
+				line("c", http.MethodPost, "/v1/embeddings", `{}`) +
+				line("d", http.MethodPost, BatchEndpoint, `"text"`),
+			wantTotal: 7,
+			wantCodes: []string{"invalid_json_line", "missing_required_parameter", "duplicate_custom_id", "invalid_method", "invalid_url", "invalid_request"},
+			wantLines: []int{2, 3, 4, 5, 6, 7},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			total, lineErrors := validateBatchInput(writeTestFile(t, test.content))
+			if total != test.wantTotal {
+				t.Errorf("total = %d, want %d", total, test.wantTotal)
+			}
+			var codes []string
+			var lines []int
+			for _, lineError := range lineErrors {
+				codes = append(codes, lineError.Code)
+				lines = append(lines, lineError.Line)
+			}
+			if !reflect.DeepEqual(codes, test.wantCodes) || !reflect.DeepEqual(lines, test.wantLines) {
+				t.Errorf("errors = %v at lines %v, want %v at lines %v", codes, lines, test.wantCodes, test.wantLines)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, lineErrors := validateBatchInput(filepath.Join(t.TempDir(), "missing.jsonl"))
+		if len(lineErrors) != 1 || lineErrors[0].Code != "file_unreadable" {
+			t.Errorf("errors = %v, want file_unreadable", lineErrors)
+		}
+	})
+}
+
+func TestRecoverResultFile(t *testing.T) {
+	complete := `{"id":"r1","custom_id":"ok","response":{"status_code":200,"request_id":"q1","body":{}},"error":null}` + "\n" +
+		`{"id":"r2","custom_id":"rejected","response":{"status_code":400,"request_id":"q2","body":{}},"error":null}` + "\n" +
+		`{"id":"r3","custom_id":"unanswered","response":null,"error":{"code":"request_failed","message":"no response"}}` + "\n"
+	path := writeTestFile(t, complete+`{"id":"r4","custom_id":"cut`)
+
+	done := make(map[string]bool)
+	succeeded, failed := recoverResultFile(path, done)
+	if succeeded != 1 || failed != 2 {
+		t.Errorf("recoverResultFile() = %d succeeded, %d failed, want 1, 2", succeeded, failed)
+	}
+	if want := map[string]bool{"ok": true, "rejected": true, "unanswered": true}; !reflect.DeepEqual(done, want) {
+		t.Errorf("done = %v, want %v", done, want)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != complete {
+		t.Errorf("the line cut short was not truncated: %q", strings.TrimPrefix(string(data), complete))
+	}
+
+	if succeeded, failed := recoverResultFile(filepath.Join(t.TempDir(), "missing.jsonl"), done); succeeded != 0 || failed != 0 {
+		t.Errorf("missing file recovered %d, %d results", succeeded, failed)
+	}
+}
+
+func TestBatchEndStatus(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		record batchRecord
+		want   string
+	}{
+		{
+			name:   "all requests done",
+			record: batchRecord{Batch: Batch{Status: BatchInProgress, ExpiresAt: now.Add(time.Hour).Unix(), RequestCounts: BatchRequestCounts{Total: 2, Completed: 2}}},
+			want:   BatchCompleted,
+		},
+		{
+			name:   "cancelled",
+			record: batchRecord{Batch: Batch{Status: BatchCancelling, CancellingAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}},
+			want:   BatchCancelled,
+		},
+		{
+			name:   "resumed while finalizing after a cancel",
+			record: batchRecord{Batch: Batch{Status: BatchFinalizing, CancellingAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}},
+			want:   BatchCancelled,
+		},
+		{
+			name:   "resumed while finalizing after a failure",
+			record: batchRecord{Batch: Batch{Status: BatchFinalizing, Errors: &BatchErrors{Object: "list"}, ExpiresAt: now.Add(time.Hour).Unix()}},
+			want:   BatchFailed,
+		},
+		{
+			name:   "expired with requests left",
+			record: batchRecord{Batch: Batch{Status: BatchInProgress, ExpiresAt: now.Add(-time.Minute).Unix(), RequestCounts: BatchRequestCounts{Total: 2, Completed: 1}}},
+			want:   BatchExpired,
+		},
+		{
+			name:   "finished just after expiry",
+			record: batchRecord{Batch: Batch{Status: BatchInProgress, ExpiresAt: now.Add(-time.Minute).Unix(), RequestCounts: BatchRequestCounts{Total: 2, Completed: 1, Failed: 1}}},
+			want:   BatchCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := batchEndStatus(&test.record, now); got != test.want {
+				t.Errorf("batchEndStatus() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProcessBatchRequestsMissingInput(t *testing.T) {
+	dir := t.TempDir()
+	record := &batchRecord{Batch: Batch{ID: "batch_test", Status: BatchInProgress, ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+
+	err := processBatchRequests(record, "", filepath.Join(dir, "deleted.jsonl"), filepath.Join(dir, "output.jsonl"), filepath.Join(dir, "error.jsonl"))
+	if err == nil {
+		t.Fatal("processBatchRequests() succeeded without its input file")
+	}
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"deepinfra-wrapper/types"
+)
+
+// Response cache backends
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendDisk   = "disk"
+)
+
+// CachedResponse is a completed chat completion kept for identical requests.
+// Body is always the non-streamed completion; streamed requests are
+// aggregated before they are stored and replayed as chunks.
+type CachedResponse struct {
+	Model    string          `json:"model"`
+	Body     json.RawMessage `json:"body"`
+	StoredAt int64           `json:"stored_at"`
+}
+
+// ResponseCache stores serialized responses by key
+type ResponseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Len() int
+}
+
+var (
+	responseCache         ResponseCache
+	responseCacheMaxBytes int64 = 64 << 20
+	cacheHits             int64
+	cacheMisses           int64
+	cacheMutex            sync.Mutex
+)
+
+// InitResponseCache enables response caching with the given backend. An
+// empty backend leaves caching disabled. Non-positive limits keep the
+// defaults.
+func InitResponseCache(backend, dir string, ttl time.Duration, maxEntries int, maxBytes int64) error {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	responseCacheMaxBytes = maxBytes
+
+	switch backend {
+	case "":
+		return nil
+	case CacheBackendMemory:
+		responseCache = newMemoryCache(ttl, maxEntries, maxBytes)
+	case CacheBackendDisk:
+		cache, err := newDiskCache(dir, ttl, maxEntries, maxBytes)
+		if err != nil {
+			return err
+		}
+		responseCache = cache
+	default:
+		return fmt.Errorf("unknown response cache %q: must be memory or disk", backend)
+	}
+
+	fmt.Printf("🗄️ Response cache enabled (%s, ttl %s, up to %d entries)\n", backend, ttl, maxEntries)
+	return nil
+}
+
+// IsResponseCacheEnabled reports whether a cache backend is configured
+func IsResponseCacheEnabled() bool {
+	return responseCache != nil
+}
+
+// GetResponseCacheMaxBytes returns the most a response may take up to be
+// cached. Larger responses are not worth capturing.
+func GetResponseCacheMaxBytes() int64 {
+	return responseCacheMaxBytes
+}
+
+// ResponseCacheKey hashes the normalized request of an API key, so entries
+// are never shared between keys. The stream flag, stream options and user
+// field do not change the completion and are left out, so streamed and
+// non-streamed requests share entries.
+func ResponseCacheKey(keyName string, chatReq types.ChatCompletionRequest) string {
+	chatReq.Stream = false
+	chatReq.StreamOptions = nil
+	chatReq.User = ""
+
+	data, _ := json.Marshal(chatReq)
+	// Round-tripping through a generic value sorts the keys of raw fields
+	// such as tools, so equivalent requests hash the same
+	var normalized interface{}
+	if json.Unmarshal(data, &normalized) == nil {
+		data, _ = json.Marshal(normalized)
+	}
+
+	sum := sha256.Sum256(append([]byte(keyName+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetCachedResponse looks up a response and counts the hit or miss
+func GetCachedResponse(key string) (CachedResponse, bool) {
+	if responseCache == nil {
+		return CachedResponse{}, false
+	}
+
+	var cached CachedResponse
+	data, exists := responseCache.Get(key)
+	if exists && json.Unmarshal(data, &cached) != nil {
+		exists = false
+	}
+
+	cacheMutex.Lock()
+	if exists {
+		cacheHits++
+	} else {
+		cacheMisses++
+	}
+	cacheMutex.Unlock()
+	return cached, exists
+}
+
+// StoreCachedResponse saves a response under key
+func StoreCachedResponse(key string, cached CachedResponse) {
+	if responseCache == nil {
+		return
+	}
+	cached.StoredAt = time.Now().Unix()
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	responseCache.Set(key, data)
+}
+
+// GetCacheStats returns the hit and miss counters and the number of entries
+func GetCacheStats() (hits, misses int64, entries int) {
+	if responseCache == nil {
+		return 0, 0, 0
+	}
+	entries = responseCache.Len()
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	return cacheHits, cacheMisses, entries
+}
+
+// memoryCache is an LRU cache bounded by entry count and total size
+type memoryCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int64
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryCache(ttl time.Duration, maxEntries int, maxBytes int64) *memoryCache {
+	return &memoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(key string, value []byte) {
+	if int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+	entry := &memoryEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += int64(len(value))
+
+	for len(c.entries) > c.maxEntries || c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *memoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *memoryCache) remove(element *list.Element) {
+	entry := element.Value.(*memoryEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.value))
+}
+
+// diskCache keeps one file per entry so the cache survives restarts. File
+// modification times drive both expiry and eviction of the oldest entries.
+type diskCache struct {
+	dir        string
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	mu         sync.Mutex
+}
+
+func newDiskCache(dir string, ttl time.Duration, maxEntries int, maxBytes int64) (*diskCache, error) {
+	if dir == "" {
+		dir = "data/cache"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &diskCache{dir: dir, ttl: ttl, maxEntries: maxEntries, maxBytes: maxBytes}, nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	path := c.path(key)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if time.Since(stat.ModTime()) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *diskCache) Set(key string, value []byte) {
+	if int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmpPath := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmpPath, value, 0o644); err != nil {
+		fmt.Printf("⚠️ Failed to write cache entry: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmpPath, c.path(key)); err != nil {
+		fmt.Printf("⚠️ Failed to write cache entry: %v\n", err)
+		os.Remove(tmpPath)
+		return
+	}
+	c.evict()
+}
+
+func (c *diskCache) Len() int {
+	return len(c.files())
+}
+
+// evict removes expired entries, then the oldest ones until the cache is
+// within its limits
+func (c *diskCache) evict() {
+	files := c.files()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	count := len(files)
+	for _, file := range files {
+		expired := time.Since(file.ModTime()) > c.ttl
+		if !expired && count <= c.maxEntries && size <= c.maxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, file.Name())) == nil {
+			count--
+			size -= file.Size()
+		}
+	}
+}
+
+func (c *diskCache) files() []os.FileInfo {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	return files
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"deepinfra-wrapper/types"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+func intPtr(v int) *int             { return &v }
+func int64Ptr(v int64) *int64       { return &v }
+
+func cacheKeyRequest() types.ChatCompletionRequest {
+	return types.ChatCompletionRequest{
+		Model:       "meta-llama/Meta-Llama-3.1-8B-Instruct",
+		Messages:    []types.ChatMessage{{Role: "user", Content: types.TextContent("Hello")}},
+		Temperature: float64Ptr(0),
+	}
+}
+
+func TestResponseCacheKeyCoversRequest(t *testing.T) {
+	base := ResponseCacheKey("team-a", cacheKeyRequest())
+
+	if key := ResponseCacheKey("team-b", cacheKeyRequest()); key == base {
+		t.Error("requests of different API keys share a cache key")
+	}
+
+	changes := map[string]func(*types.ChatCompletionRequest){
+		"model":             func(r *types.ChatCompletionRequest) { r.Model = "other/model" },
+		"messages":          func(r *types.ChatCompletionRequest) { r.Messages[0].Content = types.TextContent("Goodbye") },
+		"temperature":       func(r *types.ChatCompletionRequest) { r.Temperature = float64Ptr(0.5) },
+		"max_tokens":        func(r *types.ChatCompletionRequest) { r.MaxTokens = intPtr(10) },
+		"top_p":             func(r *types.ChatCompletionRequest) { r.TopP = float64Ptr(0.9) },
+		"n":                 func(r *types.ChatCompletionRequest) { r.N = 2 },
+		"stop":              func(r *types.ChatCompletionRequest) { r.Stop = json.RawMessage(`["\n"]`) },
+		"presence_penalty":  func(r *types.ChatCompletionRequest) { r.PresencePenalty = float64Ptr(1) },
+		"frequency_penalty": func(r *types.ChatCompletionRequest) { r.FrequencyPenalty = float64Ptr(1) },
+		"seed":              func(r *types.ChatCompletionRequest) { r.Seed = int64Ptr(7) },
+		"tools": func(r *types.ChatCompletionRequest) {
+			r.Tools = json.RawMessage(`[{"type":"function","function":{"name":"lookup"}}]`)
+		},
+		"tool_choice":     func(r *types.ChatCompletionRequest) { r.ToolChoice = json.RawMessage(`"required"`) },
+		"response_format": func(r *types.ChatCompletionRequest) { r.ResponseFormat = json.RawMessage(`{"type":"json_object"}`) },
+	}
+	for name, change := range changes {
+		chatReq := cacheKeyRequest()
+		change(&chatReq)
+		if ResponseCacheKey("team-a", chatReq) == base {
+			t.Errorf("changing %s does not change the cache key", name)
+		}
+	}
+
+	ignored := map[string]func(*types.ChatCompletionRequest){
+		"stream":         func(r *types.ChatCompletionRequest) { r.Stream = true },
+		"stream_options": func(r *types.ChatCompletionRequest) { r.StreamOptions = &types.StreamOptions{IncludeUsage: true} },
+		"user":           func(r *types.ChatCompletionRequest) { r.User = "alice" },
+	}
+	for name, change := range ignored {
+		chatReq := cacheKeyRequest()
+		change(&chatReq)
+		if ResponseCacheKey("team-a", chatReq) != base {
+			t.Errorf("changing %s changes the cache key", name)
+		}
+	}
+}
+
+func TestResponseCacheKeyNormalizesTools(t *testing.T) {
+	first := cacheKeyRequest()
+	first.Tools = json.RawMessage(`[{"type":"function","function":{"name":"lookup","parameters":{"type":"object"}}}]`)
+	second := cacheKeyRequest()
+	second.Tools = json.RawMessage(`[{"function":{"parameters":{"type":"object"},"name":"lookup"},"type":"function"}]`)
+
+	if ResponseCacheKey("team-a", first) != ResponseCacheKey("team-a", second) {
+		t.Error("equivalent tool definitions hash to different cache keys")
+	}
+}
+
+func TestMemoryCacheSizeBound(t *testing.T) {
+	cache := newMemoryCache(time.Hour, 10, 8)
+	cache.Set("small", []byte("1234"))
+	cache.Set("large", []byte("123456789"))
+
+	if _, ok := cache.Get("large"); ok {
+		t.Error("an entry larger than the cache was stored")
+	}
+	if _, ok := cache.Get("small"); !ok {
+		t.Error("an entry within the bound was not stored")
+	}
+
+	cache.Set("second", []byte("5678"))
+	cache.Set("third", []byte("9012"))
+	if _, ok := cache.Get("small"); ok {
+		t.Error("the oldest entry was kept past the size bound")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"strings"
+
+	"deepinfra-wrapper/types"
+)
+
+// Model types reported in the catalog
+const (
+	ModelTypeText      = "text"
+	ModelTypeImage     = "image"
+	ModelTypeAudio     = "audio"
+	ModelTypeTTS       = "tts"
+	ModelTypeEmbedding = "embedding"
+	ModelTypeReranker  = "reranker"
+)
+
+// ModelTypes lists every model type in the order they are documented
+var ModelTypes = []string{
+	ModelTypeText,
+	ModelTypeImage,
+	ModelTypeAudio,
+	ModelTypeTTS,
+	ModelTypeEmbedding,
+	ModelTypeReranker,
+}
+
+// CapabilityRule classifies every model whose ID contains Contains
+// (case-insensitively). Capabilities listed in a rule are added to the model.
+type CapabilityRule struct {
+	Contains     string             `json:"contains"`
+	Type         string             `json:"type,omitempty"`
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+}
+
+// bundledCapabilityRules covers model families DeepInfra hosts that the
+// upstream metadata does not classify. The first rule setting a type wins,
+// so more specific patterns come first.
+var bundledCapabilityRules = []CapabilityRule{
+	{Contains: "rerank", Type: ModelTypeReranker},
+	{Contains: "whisper", Type: ModelTypeAudio},
+	{Contains: "kokoro", Type: ModelTypeTTS},
+	{Contains: "tts-", Type: ModelTypeTTS},
+	{Contains: "-tts", Type: ModelTypeTTS},
+	{Contains: "/bark", Type: ModelTypeTTS},
+	{Contains: "stable-diffusion", Type: ModelTypeImage},
+	{Contains: "sdxl", Type: ModelTypeImage},
+	{Contains: "flux", Type: ModelTypeImage},
+	{Contains: "dalle", Type: ModelTypeImage},
+	{Contains: "embedding", Type: ModelTypeEmbedding},
+	{Contains: "bge-", Type: ModelTypeEmbedding},
+	{Contains: "/e5-", Type: ModelTypeEmbedding},
+	{Contains: "gte-", Type: ModelTypeEmbedding},
+	{Contains: "sentence-transformers/", Type: ModelTypeEmbedding},
+	{Contains: "vision", Type: ModelTypeText, Capabilities: &ModelCapabilities{Vision: true}},
+	{Contains: "-vl", Type: ModelTypeText, Capabilities: &ModelCapabilities{Vision: true}},
+	{Contains: "llava", Type: ModelTypeText, Capabilities: &ModelCapabilities{Vision: true}},
+	{Contains: "pixtral", Type: ModelTypeText, Capabilities: &ModelCapabilities{Vision: true}},
+}
+
+// upstreamTaskTypes maps the task names DeepInfra reports to model types
+var upstreamTaskTypes = map[string]string{
+	"text-generation":              ModelTypeText,
+	"chat":                         ModelTypeText,
+	"text-to-image":                ModelTypeImage,
+	"automatic-speech-recognition": ModelTypeAudio,
+	"text-to-speech":               ModelTypeTTS,
+	"embeddings":                   ModelTypeEmbedding,
+	"feature-extraction":           ModelTypeEmbedding,
+	"reranker":                     ModelTypeReranker,
+}
+
+// IsKnownModelType reports whether name is one of the catalog model types
+func IsKnownModelType(name string) bool {
+	for _, modelType := range ModelTypes {
+		if modelType == name {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyModel determines a model's type and capabilities from the
+// upstream metadata, falling back to the bundled table and finally to a text
+// model. Operator overrides are applied on top when the catalog is read.
+func classifyModel(modelID string, meta *types.UpstreamModelMetadata) (string, *ModelCapabilities) {
+	modelType := ""
+	var capabilities *ModelCapabilities
+
+	if meta != nil {
+		modelType = upstreamTaskTypes[strings.ToLower(meta.Type)]
+		capabilities = capabilitiesFromTags(meta.Tags)
+	}
+
+	lowerID := strings.ToLower(modelID)
+	for _, rule := range bundledCapabilityRules {
+		if !strings.Contains(lowerID, rule.Contains) {
+			continue
+		}
+		if modelType == "" {
+			modelType = rule.Type
+		}
+		capabilities = mergeCapabilities(capabilities, rule.Capabilities)
+	}
+
+	if modelType == "" {
+		modelType = ModelTypeText
+	}
+	return modelType, capabilities
+}
+
+// capabilitiesFromTags derives capabilities from upstream model tags
+func capabilitiesFromTags(tags []string) *ModelCapabilities {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	capabilities := &ModelCapabilities{}
+	for _, tag := range tags {
+		switch strings.ToLower(tag) {
+		case "tools", "function-calling", "function_calling":
+			capabilities.Tools = true
+		case "vision", "multimodal":
+			capabilities.Vision = true
+		case "json", "json_mode", "json-mode":
+			capabilities.JSONMode = true
+		}
+	}
+	return capabilities
+}
+
+func mergeCapabilities(base, extra *ModelCapabilities) *ModelCapabilities {
+	if extra == nil {
+		return base
+	}
+	if base == nil {
+		merged := *extra
+		return &merged
+	}
+
+	merged := *base
+	merged.Tools = merged.Tools || extra.Tools
+	merged.Vision = merged.Vision || extra.Vision
+	merged.JSONMode = merged.JSONMode || extra.JSONMode
+	return &merged
+}
+
+// applyOperatorOverrides layers the operator's capability rules and then
+// the per-model overrides from the configuration on top of the catalog entry
+func applyOperatorOverrides(info ModelInfo) ModelInfo {
+	configMutex.RLock()
+	rules := config.CapabilityRules
+	configMutex.RUnlock()
+
+	lowerID := strings.ToLower(info.ID)
+	typeSet := false
+	for _, rule := range rules {
+		if !strings.Contains(lowerID, strings.ToLower(rule.Contains)) {
+			continue
+		}
+		if rule.Type != "" && !typeSet {
+			info.Type = rule.Type
+			typeSet = true
+		}
+		info.Capabilities = mergeCapabilities(info.Capabilities, rule.Capabilities)
+	}
+
+	return applyModelOverride(info)
+}
+
+// applyModelOverride layers the operator's configured overrides on top of
+// the upstream-reported metadata
+func applyModelOverride(info ModelInfo) ModelInfo {
+	override, exists := getModelOverride(info.ID)
+	if !exists {
+		return info
+	}
+
+	if override.Type != "" {
+		info.Type = override.Type
+	}
+	if override.Description != "" {
+		info.Description = override.Description
+	}
+	if override.OwnedBy != "" {
+		info.OwnedBy = override.OwnedBy
+	}
+	if override.Created > 0 {
+		info.Created = override.Created
+	}
+	if override.ContextLength > 0 {
+		info.ContextLength = override.ContextLength
+	}
+	if override.MaxTokens > 0 {
+		info.MaxTokens = override.MaxTokens
+	}
+	if override.Pricing != nil {
+		info.Pricing = override.Pricing
+	}
+	if override.Capabilities != nil {
+		info.Capabilities = override.Capabilities
+	}
+	return info
+}
+
+// GetModelType returns the catalog type of a model, or "" if the model is
+// not in the catalog
+func GetModelType(modelID string) string {
+	info, exists := GetModelInfo(modelID)
+	if !exists {
+		return ""
+	}
+	return info.Type
+}
+
+// SupportsChat reports whether a model can serve chat completions. Models
+// missing from the catalog are given the benefit of the doubt.
+func SupportsChat(modelID string) bool {
+	modelType := GetModelType(modelID)
+	return modelType == "" || modelType == ModelTypeText
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"sort"
+	"strings"
+)
+
+// Capability names accepted by ModelFilter
+const (
+	CapabilityTools    = "tools"
+	CapabilityVision   = "vision"
+	CapabilityJSONMode = "json_mode"
+)
+
+// ModelFilter selects catalog models. Zero-valued fields match everything.
+type ModelFilter struct {
+	Type             string
+	Owner            string
+	Capabilities     []string
+	MinContextLength int
+	Search           string
+}
+
+// Matches reports whether a model satisfies every condition of the filter
+func (f ModelFilter) Matches(info ModelInfo) bool {
+	if f.Type != "" && !strings.EqualFold(info.Type, f.Type) {
+		return false
+	}
+	if f.Owner != "" && !strings.EqualFold(modelOwner(info), f.Owner) {
+		return false
+	}
+	for _, capability := range f.Capabilities {
+		if !hasCapability(info.Capabilities, capability) {
+			return false
+		}
+	}
+	if f.MinContextLength > 0 && info.ContextLength < f.MinContextLength {
+		return false
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(info.ID), search) &&
+			!strings.Contains(strings.ToLower(info.Description), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsKnownCapability reports whether name is a capability ModelFilter understands
+func IsKnownCapability(name string) bool {
+	switch name {
+	case CapabilityTools, CapabilityVision, CapabilityJSONMode:
+		return true
+	}
+	return false
+}
+
+// FilterModels returns the models matching the filter, sorted by ID so
+// pagination cursors stay stable across catalog refreshes
+func FilterModels(models []ModelInfo, filter ModelFilter) []ModelInfo {
+	var matched []ModelInfo
+	for _, info := range models {
+		if filter.Matches(info) {
+			matched = append(matched, info)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID < matched[j].ID
+	})
+	return matched
+}
+
+// PaginateModels returns at most limit models following the model with ID
+// after, and whether more models remain. An empty after starts at the
+// beginning and a non-positive limit returns everything.
+func PaginateModels(models []ModelInfo, after string, limit int) ([]ModelInfo, bool) {
+	start := 0
+	if after != "" {
+		start = sort.Search(len(models), func(i int) bool {
+			return models[i].ID > after
+		})
+	}
+
+	page := models[start:]
+	if limit > 0 && len(page) > limit {
+		return page[:limit], true
+	}
+	return page, false
+}
+
+// modelOwner is the organization prefix of a model ID such as "meta-llama"
+// in "meta-llama/Llama-2-70b-chat-hf", falling back to the reported owner
+func modelOwner(info ModelInfo) string {
+	if i := strings.Index(info.ID, "/"); i > 0 {
+		return info.ID[:i]
+	}
+	return info.OwnedBy
+}
+
+func hasCapability(capabilities *ModelCapabilities, name string) bool {
+	if capabilities == nil {
+		return false
+	}
+
+	switch name {
+	case CapabilityTools:
+		return capabilities.Tools
+	case CapabilityVision:
+		return capabilities.Vision
+	case CapabilityJSONMode:
+		return capabilities.JSONMode
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"deepinfra-wrapper/types"
+)
+
+// synthesizedChunkSize is the approximate number of bytes of content per
+// chunk when a complete response is replayed as a stream
+const synthesizedChunkSize = 24
+
+// CompletionToChunks converts a complete chat completion into the chunks a
+// streamed response would have produced: for every choice a chunk with the
+// role, the content split into several chunks, one with the tool calls if
+// any, and one with the finish reason. With includeUsage, usage, when known,
+// follows in a final chunk without choices, as it does for clients that set
+// stream_options.include_usage.
+func CompletionToChunks(completion types.ChatCompletionResponse, includeUsage bool) []types.ChatCompletionChunk {
+	newChunk := func(choices ...types.ChunkChoice) types.ChatCompletionChunk {
+		return types.ChatCompletionChunk{
+			ID:      completion.ID,
+			Object:  "chat.completion.chunk",
+			Created: completion.Created,
+			Model:   completion.Model,
+			Choices: append([]types.ChunkChoice{}, choices...),
+		}
+	}
+
+	var chunks []types.ChatCompletionChunk
+	for _, choice := range completion.Choices {
+		role := choice.Message.Role
+		if role == "" {
+			role = "assistant"
+		}
+		empty := ""
+		chunks = append(chunks, newChunk(types.ChunkChoice{
+			Index: choice.Index,
+			Delta: types.ChunkDelta{Role: role, Content: &empty},
+		}))
+
+		for _, piece := range splitContent(choice.Message.Content.String(), synthesizedChunkSize) {
+			piece := piece
+			chunks = append(chunks, newChunk(types.ChunkChoice{
+				Index: choice.Index,
+				Delta: types.ChunkDelta{Content: &piece},
+			}))
+		}
+
+		var toolCalls []types.ToolCall
+		if len(choice.Message.ToolCalls) > 0 && json.Unmarshal(choice.Message.ToolCalls, &toolCalls) == nil && len(toolCalls) > 0 {
+			deltas := make([]types.ToolCallDelta, len(toolCalls))
+			for i, call := range toolCalls {
+				deltas[i] = types.ToolCallDelta{Index: i, ID: call.ID, Type: call.Type, Function: call.Function}
+			}
+			chunks = append(chunks, newChunk(types.ChunkChoice{
+				Index: choice.Index,
+				Delta: types.ChunkDelta{ToolCalls: deltas},
+			}))
+		}
+
+		finishReason := "stop"
+		if choice.FinishReason != nil {
+			finishReason = *choice.FinishReason
+		}
+		chunks = append(chunks, newChunk(types.ChunkChoice{
+			Index:        choice.Index,
+			FinishReason: &finishReason,
+			StopReason:   choice.StopReason,
+		}))
+	}
+
+	if includeUsage && completion.Usage != nil {
+		usage := *completion.Usage
+		chunk := newChunk()
+		chunk.Usage = &usage
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// splitContent splits text into pieces of roughly size bytes, breaking only
+// after whitespace so words and multi-byte characters stay intact
+func splitContent(text string, size int) []string {
+	var pieces []string
+	for len(text) > size {
+		cut := strings.IndexAny(text[size:], " \n\t")
+		if cut < 0 {
+			break
+		}
+		cut += size + 1
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+// CompletionAggregator assembles the chunks of a streamed chat completion
+// into the equivalent complete response
+type CompletionAggregator struct {
+	completion types.ChatCompletionResponse
+	choices    map[int]*aggregatedChoice
+}
+
+type aggregatedChoice struct {
+	role         string
+	content      []byte
+	toolCalls    map[int]*types.ToolCall
+	finishReason *string
+	stopReason   json.RawMessage
+}
+
+// Add merges one chunk into the completion
+func (a *CompletionAggregator) Add(chunk types.ChatCompletionChunk) {
+	if a.choices == nil {
+		a.choices = make(map[int]*aggregatedChoice)
+	}
+	if a.completion.ID == "" {
+		a.completion.ID = chunk.ID
+		a.completion.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		a.completion.Model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		usage := *chunk.Usage
+		a.completion.Usage = &usage
+	}
+
+	for _, delta := range chunk.Choices {
+		choice, exists := a.choices[delta.Index]
+		if !exists {
+			choice = &aggregatedChoice{toolCalls: make(map[int]*types.ToolCall)}
+			a.choices[delta.Index] = choice
+		}
+		if delta.Delta.Role != "" {
+			choice.role = delta.Delta.Role
+		}
+		if delta.Delta.Content != nil {
+			choice.content = append(choice.content, *delta.Delta.Content...)
+		}
+		for _, callDelta := range delta.Delta.ToolCalls {
+			call, exists := choice.toolCalls[callDelta.Index]
+			if !exists {
+				call = &types.ToolCall{Type: "function"}
+				choice.toolCalls[callDelta.Index] = call
+			}
+			if callDelta.ID != "" {
+				call.ID = callDelta.ID
+			}
+			if callDelta.Type != "" {
+				call.Type = callDelta.Type
+			}
+			call.Function.Name += callDelta.Function.Name
+			call.Function.Arguments += callDelta.Function.Arguments
+		}
+		if delta.FinishReason != nil {
+			reason := *delta.FinishReason
+			choice.finishReason = &reason
+		}
+		if len(delta.StopReason) > 0 {
+			choice.stopReason = delta.StopReason
+		}
+	}
+}
+
+// Finished reports whether every choice seen so far has a finish reason
+func (a *CompletionAggregator) Finished() bool {
+	if len(a.choices) == 0 {
+		return false
+	}
+	for _, choice := range a.choices {
+		if choice.finishReason == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Completion returns the assembled completion
+func (a *CompletionAggregator) Completion() types.ChatCompletionResponse {
+	completion := a.completion
+	completion.Object = "chat.completion"
+	completion.Choices = []types.ChatCompletionChoice{}
+
+	indexes := make([]int, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		choice := a.choices[index]
+		message := types.ChatMessage{Role: choice.role}
+		if message.Role == "" {
+			message.Role = "assistant"
+		}
+
+		if len(choice.toolCalls) > 0 {
+			callIndexes := make([]int, 0, len(choice.toolCalls))
+			for callIndex := range choice.toolCalls {
+				callIndexes = append(callIndexes, callIndex)
+			}
+			sort.Ints(callIndexes)
+			calls := make([]types.ToolCall, 0, len(callIndexes))
+			for _, callIndex := range callIndexes {
+				calls = append(calls, *choice.toolCalls[callIndex])
+			}
+			message.ToolCalls, _ = json.Marshal(calls)
+		}
+
+		if len(choice.content) == 0 && len(choice.toolCalls) > 0 {
+			message.Content = types.NullContent()
+		} else {
+			message.Content = types.TextContent(string(choice.content))
+		}
+
+		completion.Choices = append(completion.Choices, types.ChatCompletionChoice{
+			Index:        index,
+			Message:      message,
+			FinishReason: choice.finishReason,
+			StopReason:   choice.stopReason,
+		})
+	}
+	return completion
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"deepinfra-wrapper/types"
+)
+
+func stringPtr(v string) *string { return &v }
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func aggregate(chunks []types.ChatCompletionChunk) (types.ChatCompletionResponse, bool) {
+	var aggregator CompletionAggregator
+	for _, chunk := range chunks {
+		aggregator.Add(chunk)
+	}
+	return aggregator.Completion(), aggregator.Finished()
+}
+
+// roundTripCompletion has several choices: long text that is split into
+// several chunks, parallel tool calls without content, and text with a tool
+// call
+func roundTripCompletion(t *testing.T) types.ChatCompletionResponse {
+	calls := []types.ToolCall{
+		{ID: "call_a", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"Paris","unit":"celsius"}`}},
+		{ID: "call_b", Type: "function", Function: types.ToolCallFunction{Name: "get_time", Arguments: `{"zone":"Europe/Paris"}`}},
+	}
+	single := []types.ToolCall{
+		{ID: "call_c", Type: "function", Function: types.ToolCallFunction{Name: "search", Arguments: `{"q":"café"}`}},
+	}
+
+	return types.ChatCompletionResponse{
+		ID:      "chatcmpl-1",
+		Object:  "chat.completion",
+		Created: 1700000000,
+		Model:   "test/model",
+		Choices: []types.ChatCompletionChoice{
+			{
+				Index:        0,
+				Message:      types.ChatMessage{Role: "assistant", Content: types.TextContent(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 5) + "Übermäßig 🦊!")},
+				FinishReason: stringPtr("stop"),
+				StopReason:   json.RawMessage(`"###"`),
+			},
+			{
+				Index:        1,
+				Message:      types.ChatMessage{Role: "assistant", Content: types.NullContent(), ToolCalls: json.RawMessage(mustJSON(t, calls))},
+				FinishReason: stringPtr("tool_calls"),
+			},
+			{
+				Index:        2,
+				Message:      types.ChatMessage{Role: "assistant", Content: types.TextContent("Let me look that up."), ToolCalls: json.RawMessage(mustJSON(t, single))},
+				FinishReason: stringPtr("tool_calls"),
+			},
+			{
+				Index:        3,
+				Message:      types.ChatMessage{Role: "assistant", Content: types.TextContent("Cut off")},
+				FinishReason: stringPtr("length"),
+			},
+		},
+		Usage: &types.Usage{PromptTokens: 12, CompletionTokens: 40, TotalTokens: 52},
+	}
+}
+
+func TestCompletionToChunksRoundTrip(t *testing.T) {
+	original := roundTripCompletion(t)
+
+	chunks := CompletionToChunks(original, true)
+	aggregated, finished := aggregate(chunks)
+	if !finished {
+		t.Fatal("aggregated stream is not finished")
+	}
+	if got, want := mustJSON(t, aggregated), mustJSON(t, original); got != want {
+		t.Errorf("round trip changed the completion\n got: %s\nwant: %s", got, want)
+	}
+
+	last := chunks[len(chunks)-1]
+	if len(last.Choices) != 0 || last.Usage == nil {
+		t.Errorf("last chunk = %s, want a usage chunk without choices", mustJSON(t, last))
+	}
+	contentChunks := 0
+	for _, chunk := range chunks {
+		if len(chunk.Choices) == 1 && chunk.Choices[0].Index == 0 && chunk.Choices[0].Delta.Content != nil && *chunk.Choices[0].Delta.Content != "" {
+			contentChunks++
+		}
+	}
+	if contentChunks < 2 {
+		t.Errorf("long content was sent in %d chunks, want several", contentChunks)
+	}
+}
+
+func TestCompletionToChunksWithoutUsage(t *testing.T) {
+	chunks := CompletionToChunks(roundTripCompletion(t), false)
+	for _, chunk := range chunks {
+		if chunk.Usage != nil || len(chunk.Choices) == 0 {
+			t.Fatalf("chunk %s carries usage although it was not requested", mustJSON(t, chunk))
+		}
+	}
+
+	aggregated, _ := aggregate(chunks)
+	if aggregated.Usage != nil {
+		t.Errorf("usage = %+v, want none", aggregated.Usage)
+	}
+}
+
+func TestCompletionAggregatorRoundTrip(t *testing.T) {
+	chunk := func(choices ...types.ChunkChoice) types.ChatCompletionChunk {
+		return types.ChatCompletionChunk{ID: "chatcmpl-2", Object: "chat.completion.chunk", Created: 1700000001, Model: "test/model", Choices: choices}
+	}
+	content := func(index int, text string) types.ChunkChoice {
+		return types.ChunkChoice{Index: index, Delta: types.ChunkDelta{Content: &text}}
+	}
+	toolCall := func(index int, call types.ToolCallDelta) types.ChunkChoice {
+		return types.ChunkChoice{Index: index, Delta: types.ChunkDelta{ToolCalls: []types.ToolCallDelta{call}}}
+	}
+	finish := func(index int, reason string) types.ChunkChoice {
+		return types.ChunkChoice{Index: index, FinishReason: &reason}
+	}
+
+	// Two choices interleave; the second streams two tool calls whose
+	// arguments arrive in fragments, alternating between the calls
+	stream := []types.ChatCompletionChunk{
+		chunk(types.ChunkChoice{Index: 0, Delta: types.ChunkDelta{Role: "assistant", Content: stringPtr("")}}),
+		chunk(types.ChunkChoice{Index: 1, Delta: types.ChunkDelta{Role: "assistant"}}),
+		chunk(content(0, "Hel")),
+		chunk(toolCall(1, types.ToolCallDelta{Index: 0, ID: "call_a", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"ci`}})),
+		chunk(content(0, "lo")),
+		chunk(toolCall(1, types.ToolCallDelta{Index: 1, ID: "call_b", Type: "function", Function: types.ToolCallFunction{Name: "get_time", Arguments: `{"zone":`}})),
+		chunk(toolCall(1, types.ToolCallDelta{Index: 0, Function: types.ToolCallFunction{Arguments: `ty":"Paris"}`}})),
+		chunk(toolCall(1, types.ToolCallDelta{Index: 1, Function: types.ToolCallFunction{Arguments: `"UTC"}`}})),
+		chunk(content(0, " there")),
+		chunk(finish(0, "stop")),
+		chunk(finish(1, "tool_calls")),
+		{ID: "chatcmpl-2", Object: "chat.completion.chunk", Created: 1700000001, Model: "test/model", Choices: []types.ChunkChoice{}, Usage: &types.Usage{PromptTokens: 5, CompletionTokens: 9, TotalTokens: 14}},
+	}
+
+	aggregated, finished := aggregate(stream)
+	if !finished {
+		t.Fatal("aggregated stream is not finished")
+	}
+	if len(aggregated.Choices) != 2 {
+		t.Fatalf("aggregated %d choices, want 2", len(aggregated.Choices))
+	}
+	if text := aggregated.Choices[0].Message.Content.String(); text != "Hello there" {
+		t.Errorf("choice 0 content = %q, want %q", text, "Hello there")
+	}
+	var calls []types.ToolCall
+	if err := json.Unmarshal(aggregated.Choices[1].Message.ToolCalls, &calls); err != nil {
+		t.Fatal(err)
+	}
+	want := []types.ToolCall{
+		{ID: "call_a", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		{ID: "call_b", Type: "function", Function: types.ToolCallFunction{Name: "get_time", Arguments: `{"zone":"UTC"}`}},
+	}
+	if mustJSON(t, calls) != mustJSON(t, want) {
+		t.Errorf("tool calls = %s, want %s", mustJSON(t, calls), mustJSON(t, want))
+	}
+	if aggregated.Usage == nil || aggregated.Usage.TotalTokens != 14 {
+		t.Errorf("usage = %+v, want 14 total tokens", aggregated.Usage)
+	}
+
+	// Replaying the assembled completion as a stream and assembling it again
+	// must give the same completion
+	replayed, finished := aggregate(CompletionToChunks(aggregated, true))
+	if !finished {
+		t.Fatal("replayed stream is not finished")
+	}
+	if got, want := mustJSON(t, replayed), mustJSON(t, aggregated); got != want {
+		t.Errorf("replay changed the completion\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestCompletionAggregatorUnfinished(t *testing.T) {
+	var aggregator CompletionAggregator
+	if aggregator.Finished() {
+		t.Error("an empty stream is finished")
+	}
+	text := "partial"
+	aggregator.Add(types.ChatCompletionChunk{Choices: []types.ChunkChoice{{Index: 0, Delta: types.ChunkDelta{Content: &text}}}})
+	if aggregator.Finished() {
+		t.Error("a stream without a finish reason is finished")
+	}
+}
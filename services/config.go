@@ -0,0 +1,186 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Config is the optional operator configuration loaded from CONFIG_FILE
+type Config struct {
+	// Models overrides catalog metadata per model ID
+	Models map[string]ModelOverride `json:"models,omitempty"`
+	// Aliases maps stable virtual model names to upstream model IDs
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Fallbacks lists, per model or alias, the models to try in order when
+	// it fails with a retryable error
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+	// APIKeys are named client keys accepted in addition to API_KEY
+	APIKeys []APIKeyConfig `json:"api_keys,omitempty"`
+	// Routes are evaluated in order and the first matching rule picks the model
+	Routes []RouteRule `json:"routes,omitempty"`
+	// CapabilityRules classify models by ID pattern, overriding upstream
+	// metadata and the bundled table
+	CapabilityRules []CapabilityRule `json:"capability_rules,omitempty"`
+	// ModelPolicies and KeyPolicies constrain request parameters per model
+	// (or alias, "*" for every model) and per API key name
+	ModelPolicies map[string]ParameterPolicy `json:"model_policies,omitempty"`
+	KeyPolicies   map[string]ParameterPolicy `json:"key_policies,omitempty"`
+	// ModelPrompts, RoutePrompts and KeyPrompts attach managed prompts per
+	// model (or alias, "*" for every model), per route name and per API key
+	ModelPrompts map[string]PromptTemplate `json:"model_prompts,omitempty"`
+	RoutePrompts map[string]PromptTemplate `json:"route_prompts,omitempty"`
+	KeyPrompts   map[string]PromptTemplate `json:"key_prompts,omitempty"`
+}
+
+// APIKeyConfig is a client key and the name it is known by in routing
+// rules, policies and logs
+type APIKeyConfig struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// ModelOverride replaces the upstream-reported metadata of a model. Zero
+// values leave the upstream value untouched.
+type ModelOverride struct {
+	Type          string             `json:"type,omitempty"`
+	Description   string             `json:"description,omitempty"`
+	OwnedBy       string             `json:"owned_by,omitempty"`
+	Created       int64              `json:"created,omitempty"`
+	ContextLength int                `json:"context_length,omitempty"`
+	MaxTokens     int                `json:"max_tokens,omitempty"`
+	Pricing       *Pricing           `json:"pricing,omitempty"`
+	Capabilities  *ModelCapabilities `json:"capabilities,omitempty"`
+	// DisableStreaming marks models whose upstream cannot stream; streamed
+	// requests are sent unstreamed and the stream is synthesized
+	DisableStreaming bool `json:"disable_streaming,omitempty"`
+	// ForceStreaming requests even unstreamed requests as streams and
+	// aggregates the chunks into one completion
+	ForceStreaming bool `json:"force_streaming,omitempty"`
+	// FanOutN marks models that ignore n; requests for several choices are
+	// sent as one request per choice and the responses merged
+	FanOutN bool `json:"fan_out_n,omitempty"`
+}
+
+var (
+	config      Config
+	configMutex sync.RWMutex
+)
+
+// LoadConfig reads the JSON configuration file at path. An empty path leaves
+// the default (empty) configuration in place.
+func LoadConfig(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var newConfig Config
+	if err := json.Unmarshal(data, &newConfig); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if err := validateConfig(newConfig); err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+
+	configMutex.Lock()
+	config = newConfig
+	configMutex.Unlock()
+	return nil
+}
+
+func getModelOverride(modelID string) (ModelOverride, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	override, exists := config.Models[modelID]
+	return override, exists
+}
+
+// StreamingDisabled reports whether the operator configured a model to be
+// requested without streaming
+func StreamingDisabled(modelID string) bool {
+	override, _ := getModelOverride(modelID)
+	return override.DisableStreaming
+}
+
+// StreamingForced reports whether the operator configured a model to always
+// be requested with streaming
+func StreamingForced(modelID string) bool {
+	override, _ := getModelOverride(modelID)
+	return override.ForceStreaming
+}
+
+// FanOutEnabled reports whether the operator configured a model to have
+// requests for several choices fanned out
+func FanOutEnabled(modelID string) bool {
+	override, _ := getModelOverride(modelID)
+	return override.FanOutN
+}
+
+// validateConfig catches mistakes that would otherwise be silently ignored
+func validateConfig(c Config) error {
+	policies := make(map[string]ParameterPolicy)
+	for model, policy := range c.ModelPolicies {
+		policies["model_policies."+model] = policy
+	}
+	for key, policy := range c.KeyPolicies {
+		policies["key_policies."+key] = policy
+	}
+
+	for name, policy := range policies {
+		for _, params := range []map[string]float64{policy.Defaults, policy.Min, policy.Max} {
+			for param := range params {
+				if !isPolicyParameter(param) {
+					return fmt.Errorf("%s: unsupported parameter %q", name, param)
+				}
+			}
+		}
+		for _, param := range policy.Forbidden {
+			if !isPolicyParameter(param) && !requestFields[param] {
+				return fmt.Errorf("%s: unknown forbidden parameter %q", name, param)
+			}
+		}
+	}
+
+	routes := make(map[string]bool, len(c.Routes))
+	for _, route := range c.Routes {
+		routes[route.Name] = true
+		if route.Model == "" {
+			continue
+		}
+		if _, isAlias := c.Aliases[route.Model]; isAlias {
+			continue
+		}
+		if known, loaded := IsCatalogModel(route.Model); loaded && !known {
+			return fmt.Errorf("routes.%s: model %q is neither an alias nor in the model catalog", route.Name, route.Model)
+		}
+	}
+	for route := range c.RoutePrompts {
+		if !routes[route] {
+			return fmt.Errorf("route_prompts.%s: no route named %q", route, route)
+		}
+	}
+
+	templates := make(map[string]PromptTemplate)
+	for model, template := range c.ModelPrompts {
+		templates["model_prompts."+model] = template
+	}
+	for route, template := range c.RoutePrompts {
+		templates["route_prompts."+route] = template
+	}
+	for key, template := range c.KeyPrompts {
+		templates["key_prompts."+key] = template
+	}
+	for name, template := range templates {
+		if err := validatePromptTemplate(name, template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
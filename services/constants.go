@@ -3,12 +3,20 @@ package services
 import "time"
 
 const (
-	DeepInfraBaseURL = "https://api.deepinfra.com/v1/openai"
-	ChatEndpoint     = "/chat/completions"
-	ModelsEndpoint   = "/models"
-	ProxyListURL     = "https://api.proxyscrape.com/v3/free-proxy-list/get?request=displayproxies&protocol=http&proxy_format=ipport&format=text&anonymity=Elite,Anonymous&timeout=5000"
-	ProxyUpdateTime  = 10 * time.Minute
-	ModelsUpdateTime = 60 * time.Minute
-	MaxProxyAttempts = 10
-	MaxRetries       = 3
-)
\ No newline at end of file
+	DeepInfraBaseURL   = "https://api.deepinfra.com/v1/openai"
+	ChatEndpoint       = "/chat/completions"
+	ModelsEndpoint     = "/models"
+	EmbeddingsEndpoint = "/embeddings"
+	ProxyListURL       = "https://api.proxyscrape.com/v3/free-proxy-list/get?request=displayproxies&protocol=http&proxy_format=ipport&format=text&anonymity=Elite,Anonymous&timeout=5000"
+	ProxyUpdateTime    = 10 * time.Minute
+	ModelsUpdateTime   = 60 * time.Minute
+	MaxProxyAttempts   = 10
+	MaxRetries         = 3
+)
+
+// Version is reported by the status endpoint and can be overridden at build
+// time with -ldflags "-X deepinfra-wrapper/services.Version=..."
+var Version = "1.0.0"
+
+// StartTime is when the process started, used to report uptime
+var StartTime = time.Now()
@@ -0,0 +1,19 @@
+package services
+
+import "fmt"
+
+var maxFanOut = 8
+
+// InitFanOut sets the largest n served by fanning a request out to models
+// that ignore n. Non-positive values keep the default of 8.
+func InitFanOut(max int) {
+	if max > 0 {
+		maxFanOut = max
+		fmt.Printf("🔱 Requests for several choices fan out to at most %d upstream requests\n", maxFanOut)
+	}
+}
+
+// GetMaxFanOut returns the largest n served by fanning out
+func GetMaxFanOut() int {
+	return maxFanOut
+}
@@ -0,0 +1,28 @@
+package services
+
+import "crypto/subtle"
+
+// DefaultKeyName is the name of the key configured through API_KEY
+const DefaultKeyName = "default"
+
+// AuthenticateKey checks a client-provided key against API_KEY and the keys
+// in the configuration file, returning the name of the matching key
+func AuthenticateKey(provided string) (string, bool) {
+	if apiKey != "" && keysEqual(provided, apiKey) {
+		return DefaultKeyName, true
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	for _, key := range config.APIKeys {
+		if key.Key != "" && keysEqual(provided, key.Key) {
+			return key.Name, true
+		}
+	}
+	return "", false
+}
+
+func keysEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
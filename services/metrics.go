@@ -0,0 +1,61 @@
+package services
+
+import "sync"
+
+// Outcomes recorded for every chat completion request
+const (
+	OutcomeSuccess         = "success"
+	OutcomeUpstreamError   = "upstream_error"
+	OutcomeTimeout         = "timeout"
+	OutcomeClientCancelled = "client_cancelled"
+)
+
+var (
+	requestOutcomes = make(map[string]int64)
+	servedModels    = make(map[string]int64)
+	fallbackCount   int64
+	metricsMutex    sync.Mutex
+)
+
+// RecordRequestOutcome increments the counter for the given outcome
+func RecordRequestOutcome(outcome string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	requestOutcomes[outcome]++
+}
+
+// GetRequestOutcomes returns a snapshot of the outcome counters
+func GetRequestOutcomes() map[string]int64 {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	snapshot := make(map[string]int64, len(requestOutcomes))
+	for outcome, count := range requestOutcomes {
+		snapshot[outcome] = count
+	}
+	return snapshot
+}
+
+// RecordServedModel counts a completion served by model, noting whether it
+// was a fallback for the model the client asked for
+func RecordServedModel(model string, fallback bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	servedModels[model]++
+	if fallback {
+		fallbackCount++
+	}
+}
+
+// GetServedModels returns a snapshot of completions per served model and the
+// number of them that were served by a fallback
+func GetServedModels() (map[string]int64, int64) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	snapshot := make(map[string]int64, len(servedModels))
+	for model, count := range servedModels {
+		snapshot[model] = count
+	}
+	return snapshot, fallbackCount
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"deepinfra-wrapper/types"
@@ -20,19 +21,24 @@ var (
 	modelMetadata    map[string]ModelInfo
 	modelsMutex      sync.RWMutex
 	lastModelsUpdate time.Time
+	modelsRefreshing atomic.Bool
 	apiKey           string
 )
 
 // ModelInfo contains additional metadata about models
 type ModelInfo struct {
-	ID          string    `json:"id"`
-	Object      string    `json:"object"`
-	Created     int64     `json:"created"`
-	OwnedBy     string    `json:"owned_by"`
-	Description string    `json:"description,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Type        string    `json:"type,omitempty"`
-	Pricing     *Pricing  `json:"pricing,omitempty"`
+	ID            string             `json:"id"`
+	Object        string             `json:"object"`
+	Created       int64              `json:"created"`
+	OwnedBy       string             `json:"owned_by"`
+	Description   string             `json:"description,omitempty"`
+	ContextLength int                `json:"context_length,omitempty"`
+	MaxTokens     int                `json:"max_tokens,omitempty"` // maximum output tokens per completion
+	Type          string             `json:"type,omitempty"`
+	Pricing       *Pricing           `json:"pricing,omitempty"`
+	Capabilities  *ModelCapabilities `json:"capabilities,omitempty"`
+	Availability  *ModelAvailability `json:"availability,omitempty"`
+	AliasFor      string             `json:"alias_for,omitempty"`
 }
 
 type Pricing struct {
@@ -41,6 +47,13 @@ type Pricing struct {
 	Unit       string  `json:"unit,omitempty"`
 }
 
+// ModelCapabilities lists optional features a model supports
+type ModelCapabilities struct {
+	Tools    bool `json:"tools"`
+	Vision   bool `json:"vision"`
+	JSONMode bool `json:"json_mode"`
+}
+
 func InitAPIKey(key string) {
 	apiKey = key
 	modelMetadata = make(map[string]ModelInfo)
@@ -51,7 +64,9 @@ func GetAPIKey() string {
 }
 
 func IsAuthEnabled() bool {
-    return apiKey != ""
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return apiKey != "" || len(config.APIKeys) > 0
 }
 
 func GetModelCount() int {
@@ -60,7 +75,16 @@ func GetModelCount() int {
 	return len(supportedModels)
 }
 
-func GetSupportedModels() []string {
+// GetLastModelsUpdate returns when the model catalog was last refreshed
+func GetLastModelsUpdate() time.Time {
+	modelsMutex.RLock()
+	defer modelsMutex.RUnlock()
+	return lastModelsUpdate
+}
+
+// GetAllModels returns every model in the upstream catalog, including
+// those currently known to be unavailable
+func GetAllModels() []string {
 	modelsMutex.RLock()
 	defer modelsMutex.RUnlock()
 	
@@ -69,12 +93,28 @@ func GetSupportedModels() []string {
 	return models
 }
 
+// GetSupportedModels returns the catalog models not known to be unavailable
+func GetSupportedModels() []string {
+	var models []string
+	for _, model := range GetAllModels() {
+		if !IsModelUnavailable(model) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
 // GetModelInfo returns detailed information about a specific model
 func GetModelInfo(modelID string) (ModelInfo, bool) {
 	modelsMutex.RLock()
 	defer modelsMutex.RUnlock()
 	
 	info, exists := modelMetadata[modelID]
+	if exists {
+		info = applyOperatorOverrides(info)
+		availability := GetModelAvailability(modelID)
+		info.Availability = &availability
+	}
 	return info, exists
 }
 
@@ -85,27 +125,37 @@ func GetAllModelInfo() []ModelInfo {
 	
 	var models []ModelInfo
 	for _, modelName := range supportedModels {
-		if info, exists := modelMetadata[modelName]; exists {
-			models = append(models, info)
-		} else {
+		info, exists := modelMetadata[modelName]
+		if !exists {
 			// Fallback to basic info if detailed metadata is not available
-			models = append(models, ModelInfo{
+			info = ModelInfo{
 				ID:      modelName,
 				Object:  "model",
-				Created: time.Now().Unix(),
+				Created: lastModelsUpdate.Unix(),
 				OwnedBy: "deepinfra",
-			})
+			}
+			info.Type, info.Capabilities = classifyModel(modelName, nil)
 		}
+		info = applyOperatorOverrides(info)
+		availability := GetModelAvailability(modelName)
+		info.Availability = &availability
+		models = append(models, info)
 	}
 	return models
 }
 
 func UpdateSupportedModels() {
+	if !modelsRefreshing.CompareAndSwap(false, true) {
+		fmt.Println("⏳ Models refresh already in progress, skipping")
+		return
+	}
+	defer modelsRefreshing.Store(false)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
 	fmt.Println("🧩 Fetching all available models...")
-	newModels, modelInfo, err := fetchSupportedModels(ctx)
+	newModels, modelInfo, err := fetchAllModels(ctx)
 	if err != nil {
 		fmt.Printf("❌ Error fetching supported models: %v\n", err)
 		return
@@ -119,52 +169,13 @@ func UpdateSupportedModels() {
 			modelMetadata[id] = info
 		}
 		lastModelsUpdate = time.Now()
+		catalogStale = false
 		modelsMutex.Unlock()
-	}
-}
 
-func fetchSupportedModels(ctx context.Context) ([]string, map[string]ModelInfo, error) {
-	allModels, modelInfo, err := fetchAllModels(ctx)
-	if err != nil {
-		return nil, nil, err
-	}
-	
-	if len(allModels) == 0 {
-		return nil, nil, fmt.Errorf("no models received from API")
-	}
-	
-	fmt.Printf("🔍 Testing accessibility for %d models...\n", len(allModels))
-	
-	var wg sync.WaitGroup
-	results := make(chan string, len(allModels))
-	semaphore := make(chan struct{}, 10)
-	
-	for _, model := range allModels {
-		wg.Add(1)
-		go func(m string) {
-			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-			
-			if isModelAccessible(ctx, m) {
-				fmt.Printf("✅ Model accessible: %s\n", m)
-				results <- m
-			}
-		}(model)
-	}
-	
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	
-	var accessibleModels []string
-	for model := range results {
-		accessibleModels = append(accessibleModels, model)
+		if err := saveModelSnapshot(); err != nil {
+			fmt.Printf("⚠️  Failed to save model snapshot: %v\n", err)
+		}
 	}
-	
-	fmt.Printf("✅ Found %d accessible models out of %d total\n", len(accessibleModels), len(allModels))
-	return accessibleModels, modelInfo, nil
 }
 
 func fetchAllModels(ctx context.Context) ([]string, map[string]ModelInfo, error) {
@@ -231,13 +242,29 @@ func fetchAllModels(ctx context.Context) ([]string, map[string]ModelInfo, error)
 			models = append(models, model.ID)
 			
 			// Create enhanced model info
-			modelInfo[model.ID] = ModelInfo{
+			info := ModelInfo{
 				ID:      model.ID,
 				Object:  "model",
-				Created: currentTime,
+				Created: firstSeen(model.ID, model.Created, currentTime),
 				OwnedBy: "deepinfra",
-				Type:    inferModelType(model.ID),
 			}
+			if model.OwnedBy != "" {
+				info.OwnedBy = model.OwnedBy
+			}
+			info.Type, info.Capabilities = classifyModel(model.ID, model.Metadata)
+			if meta := model.Metadata; meta != nil {
+				info.Description = meta.Description
+				info.ContextLength = meta.ContextLength
+				info.MaxTokens = meta.MaxTokens
+				if meta.Pricing != nil {
+					info.Pricing = &Pricing{
+						InputCost:  meta.Pricing.InputTokens,
+						OutputCost: meta.Pricing.OutputTokens,
+						Unit:       "1M tokens",
+					}
+				}
+			}
+			modelInfo[model.ID] = info
 		}
 		
 		fmt.Printf("📋 Retrieved %d models from API\n", len(models))
@@ -251,29 +278,39 @@ func fetchAllModels(ctx context.Context) ([]string, map[string]ModelInfo, error)
 	return nil, nil, fmt.Errorf("failed to fetch models after %d attempts", MaxRetries)
 }
 
-// inferModelType attempts to categorize models based on their names
-func inferModelType(modelID string) string {
-	modelLower := strings.ToLower(modelID)
+// firstSeen returns a stable creation timestamp for a model: the one already
+// in the catalog if known, otherwise the upstream value, otherwise now
+func firstSeen(modelID string, upstreamCreated, now int64) int64 {
+	modelsMutex.RLock()
+	info, exists := modelMetadata[modelID]
+	modelsMutex.RUnlock()
 	
-	if strings.Contains(modelLower, "whisper") {
-		return "audio"
+	if exists && info.Created > 0 {
+		return info.Created
 	}
-	if strings.Contains(modelLower, "stable-diffusion") || strings.Contains(modelLower, "sdxl") || strings.Contains(modelLower, "dalle") {
-		return "image"
+	if upstreamCreated > 0 {
+		return upstreamCreated
 	}
-	if strings.Contains(modelLower, "embedding") {
-		return "embedding"
-	}
-	if strings.Contains(modelLower, "llama") || strings.Contains(modelLower, "gpt") || strings.Contains(modelLower, "claude") || 
-	   strings.Contains(modelLower, "mistral") || strings.Contains(modelLower, "deepseek") || strings.Contains(modelLower, "qwen") {
-		return "text"
-	}
-	
-	// Default to text for most models
-	return "text"
+	return now
 }
 
-func isModelAccessible(ctx context.Context, model string) bool {
+// probeResult is the outcome of an availability probe
+type probeResult int
+
+const (
+	// probeAccessible means the model answered the probe
+	probeAccessible probeResult = iota
+	// probeRejected means DeepInfra refused the model: it is missing or
+	// not accessible
+	probeRejected
+	// probeInconclusive means the probe said nothing about the model: no
+	// proxy worked, or the upstream was rate limited or failing
+	probeInconclusive
+)
+
+// probeModel sends a minimal chat completion to find out whether a model is
+// accessible
+func probeModel(ctx context.Context, model string) probeResult {
 	for attempts := 0; attempts < 2; attempts++ {
 		proxy := GetWorkingProxy()
 		if proxy == "" {
@@ -294,15 +331,16 @@ func isModelAccessible(ctx context.Context, model string) bool {
 			Timeout: 20 * time.Second,
 		}
 		
+		maxTokens := 10
 		chatReq := types.ChatCompletionRequest{
 			Model: model,
 			Messages: []types.ChatMessage{
 				{
 					Role:    "user",
-					Content: "Hello",
+					Content: types.TextContent("Hello"),
 				},
 			},
-			MaxTokens: 10,
+			MaxTokens: &maxTokens,
 		}
 		
 		data, err := json.Marshal(chatReq)
@@ -326,14 +364,19 @@ func isModelAccessible(ctx context.Context, model string) bool {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		
-		if strings.Contains(string(body), "Not authenticated") {
-			return false
+		switch {
+		case strings.Contains(string(body), "Not authenticated"),
+			resp.StatusCode == http.StatusUnauthorized,
+			resp.StatusCode == http.StatusForbidden,
+			resp.StatusCode == http.StatusNotFound:
+			return probeRejected
+		case resp.StatusCode == http.StatusOK:
+			return probeAccessible
 		}
-		
-		return resp.StatusCode == http.StatusOK
+		return probeInconclusive
 	}
 	
-	return false
+	return probeInconclusive
 }
 
 func IsModelSupported(model string) bool {
@@ -352,7 +395,7 @@ func IsModelSupported(model string) bool {
 	for _, supportedModel := range supportedModels {
 		if model == supportedModel {
 			modelsMutex.RUnlock()
-			return true
+			return !IsModelUnavailable(model)
 		}
 	}
 	
@@ -360,6 +403,20 @@ func IsModelSupported(model string) bool {
 	return false
 }
 
+// IsCatalogModel reports whether a model is in the catalog. loaded is false
+// while no catalog has been fetched or restored from the snapshot yet.
+func IsCatalogModel(model string) (known, loaded bool) {
+	modelsMutex.RLock()
+	defer modelsMutex.RUnlock()
+	
+	for _, supportedModel := range supportedModels {
+		if model == supportedModel {
+			return true, true
+		}
+	}
+	return false, len(supportedModels) > 0
+}
+
 func getHeaders() http.Header {
 	headers := http.Header{}
 	headers.Set("Content-Type", "application/json")
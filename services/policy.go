@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"deepinfra-wrapper/types"
+)
+
+// Numeric parameters that policies can default and clamp
+const (
+	ParamTemperature      = "temperature"
+	ParamTopP             = "top_p"
+	ParamMaxTokens        = "max_tokens"
+	ParamPresencePenalty  = "presence_penalty"
+	ParamFrequencyPenalty = "frequency_penalty"
+)
+
+// builtinDefaults are used for requests that leave these parameters out and
+// no policy defaults them
+var builtinDefaults = map[string]float64{
+	ParamTemperature: 0.7,
+	ParamMaxTokens:   15000,
+}
+
+// requestFields are the top-level fields of a chat request, beyond the
+// policy parameters, that a policy may forbid
+var requestFields = map[string]bool{
+	"model": true, "messages": true, "stream": true, "stream_options": true,
+	"n": true, "stop": true, "seed": true, "user": true,
+	"logit_bias": true, "logprobs": true, "top_logprobs": true, "max_completion_tokens": true,
+	"tools": true, "tool_choice": true, "parallel_tool_calls": true, "functions": true, "function_call": true,
+	"response_format": true, "service_tier": true, "store": true, "metadata": true,
+	"modalities": true, "audio": true, "prediction": true, "reasoning_effort": true,
+}
+
+func isPolicyParameter(param string) bool {
+	switch param {
+	case ParamTemperature, ParamTopP, ParamMaxTokens, ParamPresencePenalty, ParamFrequencyPenalty:
+		return true
+	}
+	return false
+}
+
+// ParameterPolicy constrains the parameters of chat requests. Defaults are
+// only used when the client did not send the parameter, Min and Max clamp
+// whatever value ends up in the request, and requests sending a Forbidden
+// parameter are rejected.
+type ParameterPolicy struct {
+	Defaults  map[string]float64 `json:"defaults,omitempty"`
+	Min       map[string]float64 `json:"min,omitempty"`
+	Max       map[string]float64 `json:"max,omitempty"`
+	Forbidden []string           `json:"forbidden,omitempty"`
+}
+
+// ForbiddenParameterError reports a request parameter a policy does not allow
+type ForbiddenParameterError struct {
+	Parameter string
+}
+
+func (e *ForbiddenParameterError) Error() string {
+	return fmt.Sprintf("The parameter '%s' is not allowed for this model or API key", e.Parameter)
+}
+
+// GetParameterPolicy merges the policies that apply to a request: the
+// built-in defaults, the "*" model policy, the policies of the requested and
+// upstream model, and the policy of the API key. Later defaults win, clamps
+// keep the tightest bound and forbidden parameters accumulate.
+func GetParameterPolicy(models []string, keyName string) ParameterPolicy {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	var policies []ParameterPolicy
+	if policy, exists := config.ModelPolicies["*"]; exists {
+		policies = append(policies, policy)
+	}
+	seen := make(map[string]bool)
+	for _, model := range models {
+		if seen[model] {
+			continue
+		}
+		seen[model] = true
+		if policy, exists := config.ModelPolicies[model]; exists {
+			policies = append(policies, policy)
+		}
+	}
+	if policy, exists := config.KeyPolicies[keyName]; exists && keyName != "" {
+		policies = append(policies, policy)
+	}
+
+	merged := ParameterPolicy{
+		Defaults: make(map[string]float64, len(builtinDefaults)),
+		Min:      make(map[string]float64),
+		Max:      make(map[string]float64),
+	}
+	for param, value := range builtinDefaults {
+		merged.Defaults[param] = value
+	}
+	forbidden := make(map[string]bool)
+	for _, policy := range policies {
+		for param, value := range policy.Defaults {
+			merged.Defaults[param] = value
+		}
+		for param, value := range policy.Min {
+			if current, exists := merged.Min[param]; !exists || value > current {
+				merged.Min[param] = value
+			}
+		}
+		for param, value := range policy.Max {
+			if current, exists := merged.Max[param]; !exists || value < current {
+				merged.Max[param] = value
+			}
+		}
+		for _, param := range policy.Forbidden {
+			forbidden[param] = true
+		}
+	}
+	for param := range forbidden {
+		merged.Forbidden = append(merged.Forbidden, param)
+	}
+	sort.Strings(merged.Forbidden)
+	return merged
+}
+
+// Apply enforces the policy on a request. present holds the top-level
+// fields the client sent, so forbidden parameters are detected even when
+// the request type does not model them.
+func (p ParameterPolicy) Apply(chatReq *types.ChatCompletionRequest, present map[string]bool) error {
+	for _, param := range p.Forbidden {
+		if present[param] {
+			return &ForbiddenParameterError{Parameter: param}
+		}
+	}
+
+	for param, value := range p.Defaults {
+		applyFloatDefault(chatReq, param, value)
+	}
+	for param, bound := range p.Min {
+		clampFloat(chatReq, param, func(v float64) float64 {
+			if v < bound {
+				return bound
+			}
+			return v
+		})
+	}
+	for param, bound := range p.Max {
+		clampFloat(chatReq, param, func(v float64) float64 {
+			if v > bound {
+				return bound
+			}
+			return v
+		})
+	}
+	return nil
+}
+
+// ClampMaxTokens lowers max_tokens to the model's output limit, if known
+func ClampMaxTokens(chatReq *types.ChatCompletionRequest, model string) {
+	info, exists := GetModelInfo(model)
+	if !exists || info.MaxTokens <= 0 || chatReq.MaxTokens == nil {
+		return
+	}
+	if *chatReq.MaxTokens > info.MaxTokens {
+		fmt.Printf("✂️ Clamping max_tokens %d to %d for %s\n", *chatReq.MaxTokens, info.MaxTokens, model)
+		limit := info.MaxTokens
+		chatReq.MaxTokens = &limit
+	}
+}
+
+func applyFloatDefault(chatReq *types.ChatCompletionRequest, param string, value float64) {
+	switch param {
+	case ParamMaxTokens:
+		if chatReq.MaxTokens == nil {
+			n := int(value)
+			chatReq.MaxTokens = &n
+		}
+	default:
+		if field := floatField(chatReq, param); field != nil && *field == nil {
+			v := value
+			*field = &v
+		}
+	}
+}
+
+func clampFloat(chatReq *types.ChatCompletionRequest, param string, clamp func(float64) float64) {
+	switch param {
+	case ParamMaxTokens:
+		if chatReq.MaxTokens != nil {
+			n := int(clamp(float64(*chatReq.MaxTokens)))
+			chatReq.MaxTokens = &n
+		}
+	default:
+		if field := floatField(chatReq, param); field != nil && *field != nil {
+			v := clamp(**field)
+			*field = &v
+		}
+	}
+}
+
+func floatField(chatReq *types.ChatCompletionRequest, param string) **float64 {
+	switch param {
+	case ParamTemperature:
+		return &chatReq.Temperature
+	case ParamTopP:
+		return &chatReq.TopP
+	case ParamPresencePenalty:
+		return &chatReq.PresencePenalty
+	case ParamFrequencyPenalty:
+		return &chatReq.FrequencyPenalty
+	}
+	return nil
+}
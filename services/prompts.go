@@ -0,0 +1,181 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"deepinfra-wrapper/types"
+)
+
+// How a managed system prompt combines with the client's system messages
+const (
+	SystemModePrepend = "prepend"
+	SystemModeReplace = "replace"
+)
+
+// PromptTemplate is a managed prompt applied to chat requests before they
+// are sent upstream. System is inserted as the first message, ahead of the
+// client's own system messages unless SystemMode is "replace", which drops
+// them. Prefix and Suffix wrap the text of the last user message. Clients
+// may only skip the template when AllowOptOut is set.
+type PromptTemplate struct {
+	System      string `json:"system,omitempty"`
+	SystemMode  string `json:"system_mode,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	Suffix      string `json:"suffix,omitempty"`
+	AllowOptOut bool   `json:"allow_opt_out,omitempty"`
+}
+
+// PromptVariables are the values substituted for {{date}}, {{datetime}},
+// {{user}}, {{key}} and {{model}} in prompt templates
+type PromptVariables struct {
+	Time    time.Time
+	User    string
+	KeyName string
+	Model   string
+}
+
+func (v PromptVariables) replacer() *strings.Replacer {
+	now := v.Time.UTC()
+	return strings.NewReplacer(
+		"{{date}}", now.Format("2006-01-02"),
+		"{{datetime}}", now.Format(time.RFC3339),
+		"{{user}}", safePromptUser(v.User),
+		"{{key}}", v.KeyName,
+		"{{model}}", v.Model,
+	)
+}
+
+// safePromptUser returns the client supplied user for substitution into a
+// managed prompt, or an empty string when it is longer than 64 characters or
+// has anything besides letters, digits and "_.@-". This keeps clients from
+// writing their own instructions into the operator's prompt.
+func safePromptUser(user string) string {
+	if len(user) > 64 {
+		return ""
+	}
+	for _, c := range user {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("_.@-", c):
+		default:
+			return ""
+		}
+	}
+	return user
+}
+
+// GetPromptTemplates returns the templates that apply to a request, in the
+// order they are applied: the "*" model template, the templates of the
+// requested and upstream model, then those of the matched route and the API
+// key.
+func GetPromptTemplates(models []string, route, keyName string) []PromptTemplate {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	var templates []PromptTemplate
+	if template, exists := config.ModelPrompts["*"]; exists {
+		templates = append(templates, template)
+	}
+	seen := make(map[string]bool)
+	for _, model := range models {
+		if seen[model] {
+			continue
+		}
+		seen[model] = true
+		if template, exists := config.ModelPrompts[model]; exists {
+			templates = append(templates, template)
+		}
+	}
+	if template, exists := config.RoutePrompts[route]; exists && route != "" {
+		templates = append(templates, template)
+	}
+	if template, exists := config.KeyPrompts[keyName]; exists && keyName != "" {
+		templates = append(templates, template)
+	}
+	return templates
+}
+
+// ApplyPromptTemplates rewrites the request messages with the given
+// templates. When optOut is set, templates that allow it are skipped. The
+// system prompts of all templates are joined into a single system message.
+// It returns the number of templates applied.
+func ApplyPromptTemplates(chatReq *types.ChatCompletionRequest, templates []PromptTemplate, vars PromptVariables, optOut bool) int {
+	replacer := vars.replacer()
+
+	var systemPrompts []string
+	var prefix, suffix string
+	replaceSystem := false
+	applied := 0
+
+	for _, template := range templates {
+		if optOut && template.AllowOptOut {
+			continue
+		}
+		applied++
+		if template.System != "" {
+			systemPrompts = append(systemPrompts, replacer.Replace(template.System))
+		}
+		if template.SystemMode == SystemModeReplace {
+			replaceSystem = true
+		}
+		prefix += replacer.Replace(template.Prefix)
+		suffix = replacer.Replace(template.Suffix) + suffix
+	}
+	if applied == 0 {
+		return 0
+	}
+
+	messages := make([]types.ChatMessage, 0, len(chatReq.Messages)+1)
+	if len(systemPrompts) > 0 {
+		messages = append(messages, types.ChatMessage{
+			Role:    "system",
+			Content: types.TextContent(strings.Join(systemPrompts, "\n\n")),
+		})
+	}
+	for _, message := range chatReq.Messages {
+		if replaceSystem && message.Role == "system" {
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	if prefix != "" || suffix != "" {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				messages[i].Content = wrapContent(messages[i].Content, prefix, suffix)
+				break
+			}
+		}
+	}
+
+	chatReq.Messages = messages
+	return applied
+}
+
+// wrapContent adds prefix and suffix around message text. Content parts get
+// extra text parts so images stay where they are.
+func wrapContent(content types.MessageContent, prefix, suffix string) types.MessageContent {
+	if content.Parts == nil {
+		return types.TextContent(prefix + content.Text + suffix)
+	}
+
+	parts := make([]types.ContentPart, 0, len(content.Parts)+2)
+	if prefix != "" {
+		parts = append(parts, types.ContentPart{Type: "text", Text: prefix})
+	}
+	parts = append(parts, content.Parts...)
+	if suffix != "" {
+		parts = append(parts, types.ContentPart{Type: "text", Text: suffix})
+	}
+	return types.MessageContent{Parts: parts}
+}
+
+func validatePromptTemplate(name string, template PromptTemplate) error {
+	switch template.SystemMode {
+	case "", SystemModePrepend, SystemModeReplace:
+		return nil
+	}
+	return fmt.Errorf("%s: unknown system_mode %q: must be prepend or replace", name, template.SystemMode)
+}
@@ -25,6 +25,13 @@ func GetProxyCount() int {
 	return len(workingProxies)
 }
 
+// GetLastProxyUpdate returns when the proxy pool was last refreshed
+func GetLastProxyUpdate() time.Time {
+	proxyMutex.RLock()
+	defer proxyMutex.RUnlock()
+	return lastProxyUpdate
+}
+
 func UpdateWorkingProxies() {
 	proxies, err := getProxyList()
 	if err != nil {
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"net/http"
+
+	"deepinfra-wrapper/types"
+)
+
+// RouteRule sends requests matching all of its conditions to Model, which
+// may be a model ID or an alias, or keeps the requested model when Model is
+// empty. With DisableStreaming the upstream request
+// is never streamed and streams are synthesized from the complete response;
+// with ForceStreaming it is always streamed and aggregated for clients that
+// did not ask for a stream. With FanOutN requests for several choices are
+// sent as one upstream request per choice.
+type RouteRule struct {
+	Name             string     `json:"name"`
+	Match            RouteMatch `json:"match"`
+	Model            string     `json:"model,omitempty"`
+	DisableStreaming bool       `json:"disable_streaming,omitempty"`
+	ForceStreaming   bool       `json:"force_streaming,omitempty"`
+	FanOutN          bool       `json:"fan_out_n,omitempty"`
+}
+
+// RouteMatch lists the conditions of a rule. Unset conditions match any
+// request. Headers maps header names to the required value, or "*" for any.
+type RouteMatch struct {
+	Keys            []string          `json:"keys,omitempty"`
+	Models          []string          `json:"models,omitempty"`
+	MinPromptTokens int               `json:"min_prompt_tokens,omitempty"`
+	MaxPromptTokens int               `json:"max_prompt_tokens,omitempty"`
+	HasTools        *bool             `json:"has_tools,omitempty"`
+	HasImages       *bool             `json:"has_images,omitempty"`
+	Stream          *bool             `json:"stream,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+}
+
+// RouteRequest holds the request properties routing rules are matched against
+type RouteRequest struct {
+	KeyName      string      `json:"key_name,omitempty"`
+	Model        string      `json:"model"`
+	PromptTokens int         `json:"prompt_tokens"`
+	HasTools     bool        `json:"has_tools"`
+	HasImages    bool        `json:"has_images"`
+	Stream       bool        `json:"stream"`
+	Headers      http.Header `json:"-"`
+}
+
+// NewRouteRequest collects the routing properties of a chat request
+func NewRouteRequest(chatReq types.ChatCompletionRequest, keyName string, headers http.Header) RouteRequest {
+	return RouteRequest{
+		KeyName:      keyName,
+		Model:        chatReq.Model,
+		PromptTokens: EstimateRequestTokens(chatReq),
+		HasTools:     chatReq.HasTools(),
+		HasImages:    chatReq.HasImages(),
+		Stream:       chatReq.Stream,
+		Headers:      headers,
+	}
+}
+
+// MatchRoute returns the first configured rule matching the request
+func MatchRoute(req RouteRequest) (RouteRule, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	for _, rule := range config.Routes {
+		if rule.Match.matches(req) {
+			return rule, true
+		}
+	}
+	return RouteRule{}, false
+}
+
+func (m RouteMatch) matches(req RouteRequest) bool {
+	if len(m.Keys) > 0 && !containsString(m.Keys, req.KeyName) {
+		return false
+	}
+	if len(m.Models) > 0 && !containsString(m.Models, req.Model) {
+		return false
+	}
+	if m.MinPromptTokens > 0 && req.PromptTokens < m.MinPromptTokens {
+		return false
+	}
+	if m.MaxPromptTokens > 0 && req.PromptTokens > m.MaxPromptTokens {
+		return false
+	}
+	if m.HasTools != nil && *m.HasTools != req.HasTools {
+		return false
+	}
+	if m.HasImages != nil && *m.HasImages != req.HasImages {
+		return false
+	}
+	if m.Stream != nil && *m.Stream != req.Stream {
+		return false
+	}
+	for name, want := range m.Headers {
+		got := req.Headers.Get(name)
+		if got == "" || (want != "*" && got != want) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// semanticEntry is a cached completion together with the embedding of the
+// user message that produced it
+type semanticEntry struct {
+	embedding []float64
+	response  CachedResponse
+	expires   time.Time
+}
+
+var (
+	semanticModel      string
+	semanticThreshold  = 0.95
+	semanticTTL        = time.Hour
+	semanticMaxEntries = 1000
+
+	// semanticIndex holds the entries of each scope (API key, model and
+	// conversation context) in
+	// insertion order; lookups compare against every live entry of a scope
+	semanticIndex   = make(map[string][]semanticEntry)
+	semanticEntries int
+	semanticHits    int64
+	semanticMisses  int64
+	semanticMutex   sync.Mutex
+)
+
+// InitSemanticCache enables the semantic cache using the given embedding
+// model. An empty model leaves it disabled. Non-positive values keep the
+// defaults.
+func InitSemanticCache(model string, threshold float64, ttl time.Duration, maxEntries int) error {
+	if model == "" {
+		return nil
+	}
+	if threshold > 1 {
+		return fmt.Errorf("semantic cache threshold must be between 0 and 1, got %v", threshold)
+	}
+	if threshold > 0 {
+		semanticThreshold = threshold
+	}
+	if ttl > 0 {
+		semanticTTL = ttl
+	}
+	if maxEntries > 0 {
+		semanticMaxEntries = maxEntries
+	}
+	semanticModel = model
+
+	fmt.Printf("🧠 Semantic cache enabled (embeddings from %s, threshold %.2f)\n", model, semanticThreshold)
+	return nil
+}
+
+// IsSemanticCacheEnabled reports whether an embedding model is configured
+func IsSemanticCacheEnabled() bool {
+	return semanticModel != ""
+}
+
+// EmbedText returns the normalized embedding of text from the configured
+// embedding model
+func EmbedText(ctx context.Context, text string) ([]float64, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"model": semanticModel,
+		"input": []string{text},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempts := 0; attempts < MaxRetries; attempts++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		proxy := GetWorkingProxy()
+		if proxy == "" {
+			lastErr = fmt.Errorf("no working proxy available")
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+
+		embedding, err := fetchEmbedding(ctx, proxy, data)
+		if err == nil {
+			return normalizeVector(embedding), nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func fetchEmbedding(ctx context.Context, proxy string, data []byte) ([]float64, error) {
+	proxyURL, err := url.Parse("http://" + proxy)
+	if err != nil {
+		RemoveProxy(proxy)
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+		},
+		Timeout: 15 * time.Second,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", DeepInfraBaseURL+EmbeddingsEndpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = getHeaders()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			RemoveProxy(proxy)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed (%d): %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %v", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response contained no vectors")
+	}
+	return result.Data[0].Embedding, nil
+}
+
+// LookupSemanticCache returns the cached response in scope whose embedding
+// is most similar to embedding, if the similarity reaches the threshold. It
+// counts the hit or miss.
+func LookupSemanticCache(scope string, embedding []float64) (CachedResponse, float64, bool) {
+	now := time.Now()
+
+	semanticMutex.Lock()
+	defer semanticMutex.Unlock()
+
+	var best *semanticEntry
+	bestSimilarity := 0.0
+	for i := range semanticIndex[scope] {
+		entry := &semanticIndex[scope][i]
+		if now.After(entry.expires) || len(entry.embedding) != len(embedding) {
+			continue
+		}
+		if similarity := dotProduct(entry.embedding, embedding); similarity > bestSimilarity {
+			best = entry
+			bestSimilarity = similarity
+		}
+	}
+
+	if best == nil || bestSimilarity < semanticThreshold {
+		semanticMisses++
+		return CachedResponse{}, bestSimilarity, false
+	}
+	semanticHits++
+	return best.response, bestSimilarity, true
+}
+
+// StoreSemanticCache adds a response to the scope's index, evicting expired
+// entries and then the oldest ones when the cache is full
+func StoreSemanticCache(scope string, embedding []float64, response CachedResponse) {
+	now := time.Now()
+	response.StoredAt = now.Unix()
+
+	semanticMutex.Lock()
+	defer semanticMutex.Unlock()
+
+	semanticIndex[scope] = append(semanticIndex[scope], semanticEntry{
+		embedding: embedding,
+		response:  response,
+		expires:   now.Add(semanticTTL),
+	})
+	semanticEntries++
+
+	if semanticEntries > semanticMaxEntries {
+		pruneSemanticIndex(now)
+	}
+}
+
+// pruneSemanticIndex drops expired entries, then the oldest entries across
+// all scopes until the index is within its limit
+func pruneSemanticIndex(now time.Time) {
+	semanticEntries = 0
+	for scope, entries := range semanticIndex {
+		live := entries[:0]
+		for _, entry := range entries {
+			if now.Before(entry.expires) {
+				live = append(live, entry)
+			}
+		}
+		if len(live) == 0 {
+			delete(semanticIndex, scope)
+			continue
+		}
+		semanticIndex[scope] = live
+		semanticEntries += len(live)
+	}
+
+	for semanticEntries > semanticMaxEntries {
+		oldestScope := ""
+		var oldest time.Time
+		for scope, entries := range semanticIndex {
+			if oldestScope == "" || entries[0].expires.Before(oldest) {
+				oldestScope = scope
+				oldest = entries[0].expires
+			}
+		}
+		semanticIndex[oldestScope] = semanticIndex[oldestScope][1:]
+		if len(semanticIndex[oldestScope]) == 0 {
+			delete(semanticIndex, oldestScope)
+		}
+		semanticEntries--
+	}
+}
+
+// GetSemanticCacheStats returns the hit and miss counters and the number of
+// indexed entries
+func GetSemanticCacheStats() (hits, misses int64, entries int) {
+	semanticMutex.Lock()
+	defer semanticMutex.Unlock()
+	return semanticHits, semanticMisses, semanticEntries
+}
+
+// normalizeVector scales v to unit length so that cosine similarity is a
+// plain dot product
+func normalizeVector(v []float64) []float64 {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return v
+	}
+
+	normalized := make([]float64, len(v))
+	for i, x := range v {
+		normalized[i] = x / norm
+	}
+	return normalized
+}
+
+func dotProduct(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
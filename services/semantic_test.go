@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// resetSemanticCache empties the index and restores the settings after the
+// test
+func resetSemanticCache(t *testing.T, threshold float64, maxEntries int) {
+	t.Helper()
+	previousThreshold, previousMax := semanticThreshold, semanticMaxEntries
+	semanticThreshold, semanticMaxEntries = threshold, maxEntries
+	semanticIndex = make(map[string][]semanticEntry)
+	semanticEntries, semanticHits, semanticMisses = 0, 0, 0
+
+	t.Cleanup(func() {
+		semanticThreshold, semanticMaxEntries = previousThreshold, previousMax
+		semanticIndex = make(map[string][]semanticEntry)
+		semanticEntries, semanticHits, semanticMisses = 0, 0, 0
+	})
+}
+
+func TestSemanticCacheScopes(t *testing.T) {
+	resetSemanticCache(t, 0.95, 100)
+
+	embedding := []float64{1, 0}
+	StoreSemanticCache("team-a/model-x/context-1", embedding, CachedResponse{Model: "model-x", Body: []byte(`"answer"`)})
+
+	if _, _, hit := LookupSemanticCache("team-a/model-x/context-1", embedding); !hit {
+		t.Error("identical query in the same scope missed")
+	}
+	for _, scope := range []string{
+		"team-b/model-x/context-1",
+		"team-a/model-y/context-1",
+		"team-a/model-x/context-2",
+	} {
+		if _, _, hit := LookupSemanticCache(scope, embedding); hit {
+			t.Errorf("query in scope %s hit an entry of another scope", scope)
+		}
+	}
+
+	hits, misses, entries := GetSemanticCacheStats()
+	if hits != 1 || misses != 3 || entries != 1 {
+		t.Errorf("stats = %d hits, %d misses, %d entries, want 1, 3, 1", hits, misses, entries)
+	}
+}
+
+func TestSemanticCacheThreshold(t *testing.T) {
+	resetSemanticCache(t, 0.95, 100)
+	StoreSemanticCache("scope", []float64{1, 0}, CachedResponse{Body: []byte(`"answer"`)})
+
+	tests := []struct {
+		name      string
+		embedding []float64
+		want      bool
+	}{
+		{name: "identical", embedding: []float64{1, 0}, want: true},
+		{name: "above threshold", embedding: []float64{0.96, 0.28}, want: true},
+		{name: "below threshold", embedding: []float64{0.8, 0.6}, want: false},
+		{name: "orthogonal", embedding: []float64{0, 1}, want: false},
+		{name: "different dimensions", embedding: []float64{1, 0, 0}, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, similarity, hit := LookupSemanticCache("scope", test.embedding)
+			if hit != test.want {
+				t.Errorf("hit = %v (similarity %.3f), want %v", hit, similarity, test.want)
+			}
+		})
+	}
+}
+
+func TestSemanticCachePruneKeepsNewest(t *testing.T) {
+	resetSemanticCache(t, 0.95, 3)
+
+	embeddings := [][]float64{{1, 0}, {0, 1}, {-1, 0}, {0, -1}, {0.6, 0.8}}
+	scopes := []string{"a", "b", "a", "b", "a"}
+	for i, embedding := range embeddings {
+		StoreSemanticCache(scopes[i], embedding, CachedResponse{Body: []byte(`"answer"`)})
+		// Entries are ordered by their expiry time
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, entries := GetSemanticCacheStats(); entries != 3 {
+		t.Fatalf("entries = %d, want 3", entries)
+	}
+	for i, embedding := range embeddings {
+		_, _, hit := LookupSemanticCache(scopes[i], embedding)
+		if want := i >= 2; hit != want {
+			t.Errorf("entry %d kept = %v, want %v", i, hit, want)
+		}
+	}
+}
+
+func TestSemanticCachePruneDropsExpired(t *testing.T) {
+	resetSemanticCache(t, 0.95, 100)
+	StoreSemanticCache("scope", []float64{1, 0}, CachedResponse{Body: []byte(`"answer"`)})
+
+	pruneSemanticIndex(time.Now().Add(semanticTTL + time.Second))
+	if _, _, entries := GetSemanticCacheStats(); entries != 0 {
+		t.Errorf("entries = %d after expiry, want 0", entries)
+	}
+}
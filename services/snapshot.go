@@ -0,0 +1,111 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	snapshotPath string
+	catalogStale bool
+)
+
+// modelSnapshot is the on-disk form of the model catalog
+type modelSnapshot struct {
+	UpdatedAt time.Time            `json:"updated_at"`
+	Models    []string             `json:"models"`
+	Metadata  map[string]ModelInfo `json:"metadata"`
+	// Availability is what traffic and probes have taught us about each model
+	Availability map[string]ModelAvailability `json:"availability,omitempty"`
+}
+
+// InitModelSnapshot loads the catalog persisted at path, if any, so requests
+// can be served before the first refresh completes. The loaded catalog is
+// marked stale until UpdateSupportedModels succeeds. An empty path disables
+// persistence.
+func InitModelSnapshot(path string) (int, error) {
+	snapshotPath = path
+	if path == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read model snapshot: %v", err)
+	}
+
+	var snapshot modelSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("failed to parse model snapshot: %v", err)
+	}
+
+	if len(snapshot.Models) == 0 {
+		return 0, nil
+	}
+
+	modelsMutex.Lock()
+	supportedModels = snapshot.Models
+	for id, info := range snapshot.Metadata {
+		modelMetadata[id] = info
+	}
+	lastModelsUpdate = snapshot.UpdatedAt
+	catalogStale = true
+	modelsMutex.Unlock()
+
+	restoreAvailability(snapshot.Availability)
+
+	return len(snapshot.Models), nil
+}
+
+// IsCatalogStale reports whether the catalog was loaded from a snapshot and
+// has not been refreshed from upstream yet
+func IsCatalogStale() bool {
+	modelsMutex.RLock()
+	defer modelsMutex.RUnlock()
+	return catalogStale
+}
+
+// saveModelSnapshot writes the current catalog to disk. The file is written
+// to a temporary path first so a crash never leaves a truncated snapshot.
+func saveModelSnapshot() error {
+	if snapshotPath == "" {
+		return nil
+	}
+
+	modelsMutex.RLock()
+	snapshot := modelSnapshot{
+		UpdatedAt: lastModelsUpdate,
+		Models:    append([]string(nil), supportedModels...),
+		Metadata:  make(map[string]ModelInfo, len(supportedModels)),
+	}
+	for _, id := range supportedModels {
+		if info, exists := modelMetadata[id]; exists {
+			snapshot.Metadata[id] = info
+		}
+	}
+	modelsMutex.RUnlock()
+	snapshot.Availability = copyAvailability()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(snapshotPath); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := snapshotPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, snapshotPath)
+}
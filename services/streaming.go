@@ -0,0 +1,41 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	streamHeartbeatInterval time.Duration
+	streamStallTimeout      = 60 * time.Second
+)
+
+// InitStreamTimeouts configures SSE keep-alive heartbeats, sent after the
+// given interval without output, and the longest pause allowed between
+// upstream stream lines. A zero heartbeat interval disables heartbeats; a
+// non-positive stall timeout keeps the default of one minute.
+func InitStreamTimeouts(heartbeat, stall time.Duration) {
+	streamHeartbeatInterval = heartbeat
+	if stall > 0 {
+		streamStallTimeout = stall
+	}
+
+	if heartbeat > 0 {
+		fmt.Printf("💓 Stream heartbeats every %s of idle time\n", heartbeat)
+	}
+	if stall > 0 {
+		fmt.Printf("⏱️ Upstream streams are aborted after stalling for %s\n", stall)
+	}
+}
+
+// GetStreamHeartbeatInterval returns the idle time after which a heartbeat
+// is sent to streaming clients, or 0 when heartbeats are disabled
+func GetStreamHeartbeatInterval() time.Duration {
+	return streamHeartbeatInterval
+}
+
+// GetStreamStallTimeout returns the longest pause allowed between upstream
+// stream lines
+func GetStreamStallTimeout() time.Duration {
+	return streamStallTimeout
+}
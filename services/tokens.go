@@ -0,0 +1,242 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"deepinfra-wrapper/types"
+)
+
+// Rough per-message overhead for role markers and separators in chat templates
+const messageTokenOverhead = 4
+
+// Behaviours when a prompt does not fit the model's context window
+const (
+	ContextOverflowReject   = "reject"
+	ContextOverflowTruncate = "truncate"
+)
+
+// TokenEstimator counts the tokens of a piece of text. Implementations may
+// be exact tokenizers or approximations.
+type TokenEstimator interface {
+	CountTokens(text string) int
+}
+
+// TokenEstimatorFunc adapts a function to the TokenEstimator interface
+type TokenEstimatorFunc func(text string) int
+
+func (f TokenEstimatorFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+var (
+	tokenEstimators = map[string]TokenEstimator{
+		"heuristic": TokenEstimatorFunc(heuristicTokenCount),
+		"chars":     TokenEstimatorFunc(charTokenCount),
+	}
+	tokenEstimatorName = "heuristic"
+	contextOverflow    = ContextOverflowReject
+)
+
+// RegisterTokenEstimator makes an estimator selectable by name
+func RegisterTokenEstimator(name string, estimator TokenEstimator) {
+	tokenEstimators[name] = estimator
+}
+
+// InitTokenCounting selects the token estimator and what happens to prompts
+// that overflow the context window. Empty values keep the defaults.
+func InitTokenCounting(estimator, overflow string) error {
+	if estimator != "" {
+		if _, exists := tokenEstimators[estimator]; !exists {
+			var names []string
+			for name := range tokenEstimators {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown token estimator %q: must be one of %s", estimator, strings.Join(names, ", "))
+		}
+		tokenEstimatorName = estimator
+	}
+
+	switch overflow {
+	case "":
+	case ContextOverflowReject, ContextOverflowTruncate:
+		contextOverflow = overflow
+	default:
+		return fmt.Errorf("unknown context overflow mode %q: must be reject or truncate", overflow)
+	}
+	return nil
+}
+
+// GetTokenEstimatorName returns the name of the active token estimator
+func GetTokenEstimatorName() string {
+	return tokenEstimatorName
+}
+
+// CountTokens counts the tokens of text with the active estimator
+func CountTokens(text string) int {
+	return tokenEstimators[tokenEstimatorName].CountTokens(text)
+}
+
+// EstimatePromptTokens counts the prompt tokens of a list of messages,
+// including the per-message chat template overhead
+func EstimatePromptTokens(messages []types.ChatMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += messageTokenOverhead + CountTokens(message.Content.String())
+	}
+	return tokens
+}
+
+// EstimateRequestTokens counts the prompt tokens of a chat request,
+// including its tool definitions
+func EstimateRequestTokens(chatReq types.ChatCompletionRequest) int {
+	tokens := EstimatePromptTokens(chatReq.Messages)
+	if chatReq.HasTools() {
+		tokens += CountTokens(string(chatReq.Tools))
+	}
+	return tokens
+}
+
+// ContextLengthError reports a prompt that does not fit the model's window
+type ContextLengthError struct {
+	Model         string
+	ContextLength int
+	PromptTokens  int
+	MaxTokens     int
+}
+
+func (e *ContextLengthError) Error() string {
+	if e.MaxTokens > 0 {
+		return fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+			e.ContextLength, e.PromptTokens+e.MaxTokens, e.PromptTokens, e.MaxTokens)
+	}
+	return fmt.Sprintf("This model's maximum context length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.",
+		e.ContextLength, e.PromptTokens)
+}
+
+// FitContextWindow checks the request against the model's context window.
+// A max_tokens the client did not send is only a default and is lowered to
+// the room the prompt leaves. In truncate mode the oldest non-system messages
+// are dropped until the prompt fits, always keeping the last message;
+// otherwise, or when even that is not enough, a ContextLengthError is
+// returned. Models with an unknown context length are not checked.
+func FitContextWindow(chatReq *types.ChatCompletionRequest, model string, maxTokensSent bool) error {
+	info, exists := GetModelInfo(model)
+	if !exists || info.ContextLength <= 0 {
+		return nil
+	}
+
+	if chatReq.MaxTokens != nil && !maxTokensSent {
+		room := info.ContextLength - EstimateRequestTokens(*chatReq)
+		if room < 1 {
+			room = 1
+		}
+		if *chatReq.MaxTokens > room {
+			chatReq.MaxTokens = &room
+		}
+	}
+
+	maxTokens := 0
+	if chatReq.MaxTokens != nil {
+		maxTokens = *chatReq.MaxTokens
+	}
+	fits := func() (int, bool) {
+		tokens := EstimateRequestTokens(*chatReq)
+		return tokens, tokens+maxTokens <= info.ContextLength
+	}
+
+	tokens, ok := fits()
+	if ok {
+		return nil
+	}
+
+	if contextOverflow == ContextOverflowTruncate {
+		dropped := 0
+		for !ok {
+			start, end := oldestDroppableMessages(chatReq.Messages)
+			if start < 0 {
+				break
+			}
+			chatReq.Messages = append(chatReq.Messages[:start:start], chatReq.Messages[end:]...)
+			dropped += end - start
+			tokens, ok = fits()
+		}
+		if ok {
+			fmt.Printf("✂️ Dropped %d oldest messages to fit the %d token context of %s\n", dropped, info.ContextLength, model)
+			return nil
+		}
+	}
+
+	return &ContextLengthError{
+		Model:         model,
+		ContextLength: info.ContextLength,
+		PromptTokens:  tokens,
+		MaxTokens:     maxTokens,
+	}
+}
+
+// oldestDroppableMessages returns the range [start, end) of the oldest
+// messages that can be dropped, or -1. System messages and the last message
+// are kept. An assistant message with tool calls is dropped together with
+// the tool messages answering it, and tool messages left at the front are
+// dropped together, since upstreams reject unanswered calls and replies
+// without their call.
+func oldestDroppableMessages(messages []types.ChatMessage) (start, end int) {
+	for i := 0; i < len(messages)-1; i++ {
+		if messages[i].Role == "system" {
+			continue
+		}
+
+		end = i + 1
+		if messages[i].Role == "tool" || (messages[i].Role == "assistant" && hasToolCalls(messages[i])) {
+			for end < len(messages) && messages[end].Role == "tool" {
+				end++
+			}
+		}
+		if end >= len(messages) {
+			return -1, -1
+		}
+		return i, end
+	}
+	return -1, -1
+}
+
+func hasToolCalls(message types.ChatMessage) bool {
+	calls := strings.TrimSpace(string(message.ToolCalls))
+	return calls != "" && calls != "null" && calls != "[]"
+}
+
+// charTokenCount assumes about four characters per token
+func charTokenCount(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// heuristicTokenCount approximates BPE tokenizers: runs of ASCII letters
+// and digits cost a token per four characters, punctuation a token each and
+// non-ASCII characters (CJK, emoji) about a token each
+func heuristicTokenCount(text string) int {
+	tokens := 0
+	run := 0
+	flush := func() {
+		tokens += (run + 3) / 4
+		run = 0
+	}
+
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			run++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"deepinfra-wrapper/types"
+)
+
+func textMessage(role, content string) types.ChatMessage {
+	return types.ChatMessage{Role: role, Content: types.TextContent(content)}
+}
+
+func toolCallMessage(ids ...string) types.ChatMessage {
+	var calls []map[string]interface{}
+	for _, id := range ids {
+		calls = append(calls, map[string]interface{}{
+			"id":       id,
+			"type":     "function",
+			"function": map[string]string{"name": "lookup", "arguments": "{}"},
+		})
+	}
+	data, _ := json.Marshal(calls)
+	return types.ChatMessage{Role: "assistant", ToolCalls: data}
+}
+
+func toolReply(id, content string) types.ChatMessage {
+	return types.ChatMessage{Role: "tool", Content: types.TextContent(content), ToolCallID: id}
+}
+
+// withContextWindow registers a model with the given context length and
+// counts one token per character, so each text message costs its length
+// plus messageTokenOverhead
+func withContextWindow(t *testing.T, contextLength int, overflow string) string {
+	t.Helper()
+	const model = "test/context-model"
+
+	RegisterTokenEstimator("test-bytes", TokenEstimatorFunc(func(text string) int { return len(text) }))
+	previousEstimator, previousOverflow := tokenEstimatorName, contextOverflow
+	tokenEstimatorName, contextOverflow = "test-bytes", overflow
+
+	modelsMutex.Lock()
+	if modelMetadata == nil {
+		modelMetadata = make(map[string]ModelInfo)
+	}
+	modelMetadata[model] = ModelInfo{ID: model, ContextLength: contextLength}
+	modelsMutex.Unlock()
+
+	t.Cleanup(func() {
+		tokenEstimatorName, contextOverflow = previousEstimator, previousOverflow
+		modelsMutex.Lock()
+		delete(modelMetadata, model)
+		modelsMutex.Unlock()
+	})
+	return model
+}
+
+func messageSummary(messages []types.ChatMessage) []string {
+	summary := make([]string, len(messages))
+	for i, message := range messages {
+		switch {
+		case message.Role == "tool":
+			summary[i] = "tool:" + message.ToolCallID
+		case hasToolCalls(message):
+			summary[i] = "assistant:calls"
+		default:
+			summary[i] = message.Role + ":" + message.Content.String()
+		}
+	}
+	return summary
+}
+
+func TestOldestDroppableMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		messages   []types.ChatMessage
+		start, end int
+	}{
+		{
+			name:     "skips leading system messages",
+			messages: []types.ChatMessage{textMessage("system", "rules"), textMessage("user", "one"), textMessage("user", "two")},
+			start:    1, end: 2,
+		},
+		{
+			name:     "keeps a system message in the middle",
+			messages: []types.ChatMessage{textMessage("system", "rules"), textMessage("system", "more rules"), textMessage("assistant", "hi"), textMessage("user", "two")},
+			start:    2, end: 3,
+		},
+		{
+			name: "drops a tool call with all of its replies",
+			messages: []types.ChatMessage{
+				toolCallMessage("a", "b"), toolReply("a", "1"), toolReply("b", "2"), textMessage("user", "next"),
+			},
+			start: 0, end: 3,
+		},
+		{
+			name:     "drops orphaned tool replies together",
+			messages: []types.ChatMessage{toolReply("a", "1"), toolReply("b", "2"), textMessage("assistant", "done"), textMessage("user", "next")},
+			start:    0, end: 2,
+		},
+		{
+			name:     "never drops the last message",
+			messages: []types.ChatMessage{textMessage("system", "rules"), textMessage("user", "only")},
+			start:    -1, end: -1,
+		},
+		{
+			name:     "never drops a tool call answered by the last message",
+			messages: []types.ChatMessage{textMessage("system", "rules"), toolCallMessage("a"), toolReply("a", "1")},
+			start:    -1, end: -1,
+		},
+		{
+			name:     "empty conversation",
+			messages: nil,
+			start:    -1, end: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := oldestDroppableMessages(test.messages)
+			if start != test.start || end != test.end {
+				t.Errorf("oldestDroppableMessages() = [%d, %d), want [%d, %d)", start, end, test.start, test.end)
+			}
+		})
+	}
+}
+
+func TestFitContextWindow(t *testing.T) {
+	// Text messages below cost 10 tokens each: 6 characters plus the
+	// per-message overhead. The tool call message costs only the overhead.
+	tests := []struct {
+		name          string
+		overflow      string
+		contextLength int
+		maxTokens     int
+		defaulted     bool
+		messages      []types.ChatMessage
+		want          []string
+		wantMaxTokens int
+		wantErr       bool
+	}{
+		{
+			name:          "fitting prompt is left alone",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 100,
+			messages:      []types.ChatMessage{textMessage("system", "rules!"), textMessage("user", "first!")},
+			want:          []string{"system:rules!", "user:first!"},
+		},
+		{
+			name:          "system message is pinned",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 30,
+			messages: []types.ChatMessage{
+				textMessage("system", "rules!"), textMessage("user", "first!"), textMessage("assistant", "reply!"), textMessage("user", "second"),
+			},
+			want: []string{"system:rules!", "assistant:reply!", "user:second"},
+		},
+		{
+			name:          "completion budget counts against the window",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 35,
+			maxTokens:     20,
+			messages: []types.ChatMessage{
+				textMessage("user", "first!"), textMessage("assistant", "reply!"), textMessage("user", "second"),
+			},
+			want: []string{"user:second"},
+		},
+		{
+			name:          "defaulted max_tokens is lowered instead",
+			overflow:      ContextOverflowReject,
+			contextLength: 35,
+			maxTokens:     15000,
+			defaulted:     true,
+			messages: []types.ChatMessage{
+				textMessage("user", "first!"), textMessage("assistant", "reply!"), textMessage("user", "second"),
+			},
+			want:          []string{"user:first!", "assistant:reply!", "user:second"},
+			wantMaxTokens: 5,
+		},
+		{
+			name:          "tool call and replies are removed together",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 20,
+			messages: []types.ChatMessage{
+				textMessage("user", "first!"), toolCallMessage("a"), toolReply("a", "result"), textMessage("user", "second"),
+			},
+			want: []string{"user:second"},
+		},
+		{
+			name:          "tool call survives while it fits",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 30,
+			messages: []types.ChatMessage{
+				textMessage("user", "first!"), toolCallMessage("a"), toolReply("a", "result"), textMessage("user", "second"),
+			},
+			want: []string{"assistant:calls", "tool:a", "user:second"},
+		},
+		{
+			name:          "trimming can never fit",
+			overflow:      ContextOverflowTruncate,
+			contextLength: 15,
+			messages: []types.ChatMessage{
+				textMessage("system", "rules!"), textMessage("user", "first!"), textMessage("user", "second"),
+			},
+			wantErr: true,
+		},
+		{
+			name:          "reject mode drops nothing",
+			overflow:      ContextOverflowReject,
+			contextLength: 25,
+			messages: []types.ChatMessage{
+				textMessage("user", "first!"), textMessage("assistant", "reply!"), textMessage("user", "second"),
+			},
+			want:    []string{"user:first!", "assistant:reply!", "user:second"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := withContextWindow(t, test.contextLength, test.overflow)
+			chatReq := types.ChatCompletionRequest{Model: model, Messages: test.messages}
+			if test.maxTokens > 0 {
+				chatReq.MaxTokens = &test.maxTokens
+			}
+
+			err := FitContextWindow(&chatReq, model, !test.defaulted)
+			var lengthErr *ContextLengthError
+			if test.wantErr != errors.As(err, &lengthErr) {
+				t.Fatalf("FitContextWindow() error = %v, want error %v", err, test.wantErr)
+			}
+			if lengthErr != nil && lengthErr.ContextLength != test.contextLength {
+				t.Errorf("ContextLengthError.ContextLength = %d, want %d", lengthErr.ContextLength, test.contextLength)
+			}
+			if test.want != nil {
+				if got := messageSummary(chatReq.Messages); !reflect.DeepEqual(got, test.want) {
+					t.Errorf("messages = %v, want %v", got, test.want)
+				}
+			}
+			if test.wantMaxTokens > 0 && *chatReq.MaxTokens != test.wantMaxTokens {
+				t.Errorf("max_tokens = %d, want %d", *chatReq.MaxTokens, test.wantMaxTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// OpenAI Responses API types
+
+type ResponsesRequest struct {
+	Model              string            `json:"model"`
+	Input              ResponsesInput    `json:"input"`
+	Instructions       string            `json:"instructions,omitempty"`
+	MaxOutputTokens    *int              `json:"max_output_tokens,omitempty"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	TopP               *float64          `json:"top_p,omitempty"`
+	Tools              []ResponsesTool   `json:"tools,omitempty"`
+	ToolChoice         json.RawMessage   `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool             `json:"parallel_tool_calls,omitempty"`
+	Text               *ResponsesText    `json:"text,omitempty"`
+	Stream             bool              `json:"stream,omitempty"`
+	User               string            `json:"user,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+}
+
+// ResponsesInput is either a plain string or a list of input items. Strings
+// are decoded as a single user message.
+type ResponsesInput []ResponsesInputItem
+
+func (in *ResponsesInput) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*in = ResponsesInput{{Type: "message", Role: "user", Content: ResponsesContent{{Type: "input_text", Text: text}}}}
+		return nil
+	}
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*in = items
+	return nil
+}
+
+// ResponsesInputItem is an input item. Which fields are set depends on Type:
+// message (the default when only a role is given), function_call or
+// function_call_output.
+type ResponsesInputItem struct {
+	Type      string           `json:"type,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Role      string           `json:"role,omitempty"`
+	Content   ResponsesContent `json:"content,omitempty"`
+	CallID    string           `json:"call_id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Arguments string           `json:"arguments,omitempty"`
+	Output    string           `json:"output,omitempty"`
+}
+
+// ResponsesContent is either a plain string or a list of content parts.
+// Strings are decoded as a single input_text part.
+type ResponsesContent []ResponsesContentPart
+
+func (c *ResponsesContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*c = ResponsesContent{{Type: "input_text", Text: text}}
+		return nil
+	}
+	var parts []ResponsesContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*c = parts
+	return nil
+}
+
+// ResponsesContentPart is a content part: input_text, input_image,
+// output_text or refusal
+type ResponsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+// ResponsesTextFormat is text, json_object or json_schema. The schema
+// fields are only set for json_schema.
+type ResponsesTextFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+	Strict *bool           `json:"strict,omitempty"`
+}
+
+type ResponseObject struct {
+	ID                 string               `json:"id"`
+	Object             string               `json:"object"`
+	CreatedAt          int64                `json:"created_at"`
+	Status             string               `json:"status"`
+	Model              string               `json:"model"`
+	Output             []ResponseOutputItem `json:"output"`
+	Usage              *ResponsesUsage      `json:"usage"`
+	IncompleteDetails  *ResponsesIncomplete `json:"incomplete_details"`
+	Error              *ResponsesError      `json:"error"`
+	Instructions       *string              `json:"instructions"`
+	MaxOutputTokens    *int                 `json:"max_output_tokens"`
+	Temperature        *float64             `json:"temperature"`
+	TopP               *float64             `json:"top_p"`
+	ToolChoice         json.RawMessage      `json:"tool_choice"`
+	Tools              []ResponsesTool      `json:"tools"`
+	ParallelToolCalls  bool                 `json:"parallel_tool_calls"`
+	Text               ResponsesText        `json:"text"`
+	Metadata           map[string]string    `json:"metadata"`
+	PreviousResponseID *string              `json:"previous_response_id"`
+	User               string               `json:"user,omitempty"`
+}
+
+// ResponseOutputItem is an output item, either an assistant message or a
+// function_call. Content is a pointer so that messages always carry a
+// content list, even an empty one, while function calls carry none.
+type ResponseOutputItem struct {
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Status    string                 `json:"status"`
+	Role      string                 `json:"role,omitempty"`
+	Content   *[]ResponsesOutputText `json:"content,omitempty"`
+	CallID    string                 `json:"call_id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Arguments *string                `json:"arguments,omitempty"`
+}
+
+type ResponsesOutputText struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokens        int `json:"output_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+	TotalTokens int `json:"total_tokens"`
+}
+
+type ResponsesIncomplete struct {
+	Reason string `json:"reason"`
+}
+
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
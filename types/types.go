@@ -1,16 +1,209 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
 type ChatCompletionRequest struct {
-	Model       string        `json:"model"`
-	Messages    []ChatMessage `json:"messages"`
-	Stream      bool          `json:"stream"`
-	Temperature float64       `json:"temperature,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Model            string          `json:"model"`
+	Messages         []ChatMessage   `json:"messages"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	N                int             `json:"n,omitempty"`
+	Stop             json.RawMessage `json:"stop,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	User             string          `json:"user,omitempty"`
+	Tools            json.RawMessage `json:"tools,omitempty"`
+	ToolChoice       json.RawMessage `json:"tool_choice,omitempty"`
+	ResponseFormat   json.RawMessage `json:"response_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// WantsStreamUsage reports whether a streamed request asked for a final
+// usage chunk
+func (r ChatCompletionRequest) WantsStreamUsage() bool {
+	return r.Stream && r.StreamOptions != nil && r.StreamOptions.IncludeUsage
+}
+
+// HasTools reports whether the request declares any tools
+func (r ChatCompletionRequest) HasTools() bool {
+	tools := bytes.TrimSpace(r.Tools)
+	return len(tools) > 0 && !bytes.Equal(tools, []byte("null")) && !bytes.Equal(tools, []byte("[]"))
+}
+
+// HasImages reports whether any message carries an image part
+func (r ChatCompletionRequest) HasImages() bool {
+	for _, message := range r.Messages {
+		if message.Content.HasImages() {
+			return true
+		}
+	}
+	return false
 }
 
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string          `json:"role"`
+	Content    MessageContent  `json:"content"`
+	Name       string          `json:"name,omitempty"`
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+}
+
+// MessageContent is either plain text or a list of content parts, matching
+// the two forms OpenAI accepts for message content
+type MessageContent struct {
+	Text  string
+	Parts []ContentPart
+	null  bool
+}
+
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// TextContent returns message content holding plain text
+func TextContent(text string) MessageContent {
+	return MessageContent{Text: text}
+}
+
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	if c.Parts != nil {
+		return json.Marshal(c.Parts)
+	}
+	if c.null && c.Text == "" {
+		return []byte("null"), nil
+	}
+	return json.Marshal(c.Text)
+}
+
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	*c = MessageContent{}
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		c.null = true
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		c.Parts = []ContentPart{}
+		return json.Unmarshal(data, &c.Parts)
+	default:
+		return json.Unmarshal(data, &c.Text)
+	}
+}
+
+// String returns the text of the content, joining text parts with newlines
+func (c MessageContent) String() string {
+	if c.Parts == nil {
+		return c.Text
+	}
+
+	var texts []string
+	for _, part := range c.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// HasImages reports whether the content includes an image part
+func (c MessageContent) HasImages() bool {
+	for _, part := range c.Parts {
+		if part.Type == "image_url" {
+			return true
+		}
+	}
+	return false
+}
+
+// NullContent returns message content that marshals as null, as used by
+// assistant messages that only carry tool calls
+func NullContent() MessageContent {
+	return MessageContent{null: true}
+}
+
+// ChatCompletionResponse is a complete, non-streamed chat completion
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *Usage                 `json:"usage,omitempty"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason *string     `json:"finish_reason"`
+	// StopReason is the stop string or token that ended the choice, as
+	// reported by vLLM-backed models
+	StopReason json.RawMessage `json:"stop_reason,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatCompletionChunk is one server-sent event of a streamed chat completion
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+type ChunkChoice struct {
+	Index        int             `json:"index"`
+	Delta        ChunkDelta      `json:"delta"`
+	FinishReason *string         `json:"finish_reason"`
+	StopReason   json.RawMessage `json:"stop_reason,omitempty"`
+}
+
+type ChunkDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   *string         `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallDelta is a fragment of a tool call in a streamed chunk
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
 }
 
 type OpenAIError struct {
@@ -24,11 +217,27 @@ type OpenAIError struct {
 type ModelResponse struct {
 	Object string `json:"object"`
 	Data   []struct {
-		ID     string `json:"id"`
-		Object string `json:"object"`
+		ID       string                 `json:"id"`
+		Object   string                 `json:"object"`
+		Created  int64                  `json:"created"`
+		OwnedBy  string                 `json:"owned_by"`
+		Metadata *UpstreamModelMetadata `json:"metadata"`
 	} `json:"data"`
 }
 
+// UpstreamModelMetadata is the optional metadata DeepInfra reports per model
+type UpstreamModelMetadata struct {
+	Type          string   `json:"type"`
+	Description   string   `json:"description"`
+	ContextLength int      `json:"context_length"`
+	MaxTokens     int      `json:"max_tokens"`
+	Tags          []string `json:"tags"`
+	Pricing       *struct {
+		InputTokens  float64 `json:"input_tokens"`
+		OutputTokens float64 `json:"output_tokens"`
+	} `json:"pricing"`
+}
+
 // OpenAI-compatible model types
 type OpenAIModel struct {
 	ID      string `json:"id"`
@@ -38,6 +247,81 @@ type OpenAIModel struct {
 }
 
 type OpenAIModelsResponse struct {
-	Object string        `json:"object"`
-	Data   []OpenAIModel `json:"data"`
-}
\ No newline at end of file
+	Object  string        `json:"object"`
+	Data    []OpenAIModel `json:"data"`
+	FirstID string        `json:"first_id,omitempty"`
+	LastID  string        `json:"last_id,omitempty"`
+	HasMore bool          `json:"has_more"`
+}
+
+// Service status types
+type UpstreamStatus struct {
+	Name           string              `json:"name"`
+	Healthy        bool                `json:"healthy"`
+	WorkingProxies int                 `json:"working_proxies"`
+	LastRefresh    int64               `json:"last_refresh,omitempty"`
+	OpenModels     int                 `json:"open_models"`
+	Models         []ModelBreakerState `json:"models"`
+}
+
+// ModelBreakerState is the availability of one upstream model as seen by
+// traffic and probes
+type ModelBreakerState struct {
+	Model               string `json:"model"`
+	Status              string `json:"status"`
+	Breaker             string `json:"breaker"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastSuccess         int64  `json:"last_success,omitempty"`
+	LastFailure         int64  `json:"last_failure,omitempty"`
+}
+
+type CatalogStatus struct {
+	Models      int   `json:"models"`
+	LastRefresh int64 `json:"last_refresh,omitempty"`
+	AgeSeconds  int64 `json:"age_seconds"`
+	Loaded      bool  `json:"loaded"`
+	Stale       bool  `json:"stale"`
+	// Availability counts catalog models per availability state
+	Availability map[string]int `json:"availability"`
+}
+
+type CacheStatus struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+type StatusResponse struct {
+	Status        string           `json:"status"`
+	Ready         bool             `json:"ready"`
+	Version       string           `json:"version"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Catalog       CatalogStatus    `json:"catalog"`
+	Upstreams     []UpstreamStatus `json:"upstreams"`
+	QueueDepth    int              `json:"queue_depth"`
+	QueueCapacity int              `json:"queue_capacity"`
+	Requests      map[string]int64 `json:"requests"`
+	ServedModels  map[string]int64 `json:"served_models"`
+	Fallbacks     int64            `json:"fallbacks"`
+	Cache         *CacheStatus     `json:"cache,omitempty"`
+	SemanticCache *CacheStatus     `json:"semantic_cache,omitempty"`
+}
+
+// ChatSocketMessage is a frame of the chat WebSocket. Clients send "request"
+// frames carrying a chat completion request and "cancel" frames; the server
+// answers with "chunk", "done", "cancelled" and "error" frames carrying the
+// ID of the request they belong to.
+type ChatSocketMessage struct {
+	Type        string                 `json:"type"`
+	ID          string                 `json:"id,omitempty"`
+	Request     *ChatCompletionRequest `json:"request,omitempty"`
+	Chunk       *ChatCompletionChunk   `json:"chunk,omitempty"`
+	ServedModel string                 `json:"served_model,omitempty"`
+	Error       *ChatSocketError       `json:"error,omitempty"`
+}
+
+type ChatSocketError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}